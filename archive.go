@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// archivedMarker flags archived conversations in the history while they are shown.
+const archivedMarker = "[gray::d]archived[-::-] · "
+
+// staleConversations returns the titles of the conversations which are not archived yet
+// and were not touched in the given number of days.
+func staleConversations(m map[string]*Conversation, days int, now time.Time) []string {
+	cutoff := now.AddDate(0, 0, -days).Unix()
+	stale := make([]string, 0)
+	for title, c := range m {
+		if !c.Archived && c.Time < cutoff {
+			stale = append(stale, title)
+		}
+	}
+	return stale
+}
+
+// activeTitles leaves the archived conversations out of titles.
+func activeTitles(titles []string, m map[string]*Conversation) []string {
+	active := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if c, ok := m[title]; !ok || !c.Archived {
+			active = append(active, title)
+		}
+	}
+	return active
+}
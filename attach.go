@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// maxAttachSize is the largest file which can be attached to a question, in bytes.
+const maxAttachSize = 256 << 10
+
+var (
+	errAttachTooLarge = fmt.Errorf("the file is larger than %d KiB", maxAttachSize>>10)
+	errAttachBinary   = errors.New("the file is not text")
+)
+
+// codeLanguages maps file extensions to the language named after the fence of an attached file.
+var codeLanguages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".jsx":   "jsx",
+	".rs":    "rust",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".rb":    "ruby",
+	".php":   "php",
+	".lua":   "lua",
+	".sh":    "bash",
+	".bash":  "bash",
+	".zsh":   "zsh",
+	".fish":  "fish",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".xml":   "xml",
+	".md":    "markdown",
+	".tex":   "latex",
+	".r":     "r",
+	".jl":    "julia",
+	".f90":   "fortran",
+	".hs":    "haskell",
+	".ex":    "elixir",
+	".exs":   "elixir",
+	".erl":   "erlang",
+	".clj":   "clojure",
+	".scala": "scala",
+	".dart":  "dart",
+	".vim":   "vim",
+	".proto": "protobuf",
+	".tf":    "hcl",
+	".diff":  "diff",
+	".patch": "diff",
+}
+
+// codeFileNames are files whose language is known from their whole name rather than an extension.
+var codeFileNames = map[string]string{
+	"Makefile":   "makefile",
+	"Dockerfile": "dockerfile",
+	"go.mod":     "go",
+}
+
+// codeLanguage returns the language of the file at path, or "" if it is not known.
+func codeLanguage(path string) string {
+	name := filepath.Base(path)
+	if lang, ok := codeFileNames[name]; ok {
+		return lang
+	}
+	return codeLanguages[strings.ToLower(filepath.Ext(name))]
+}
+
+// fenceCode wraps content in a fenced code block of lang, below the name of the file it came from.
+// The fence is longer than any run of backticks in content so that the block cannot be closed early.
+func fenceCode(name, lang, content string) string {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return fmt.Sprintf("%s:\n%s%s\n%s%s\n", name, fence, lang, content, fence)
+}
+
+// attachFile reads the text file at path and returns it as a fenced code block to add to a question.
+func attachFile(path string) (string, error) {
+	path, err := homedir.Expand(strings.TrimSpace(path))
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxAttachSize {
+		return "", errAttachTooLarge
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) || strings.ContainsRune(string(data), 0) {
+		return "", errAttachBinary
+	}
+	return fenceCode(filepath.Base(path), codeLanguage(path), string(data)), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// Backups are named after the time they were taken so that they sort by age.
+const (
+	backupPrefix     = "history-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102-150405"
+)
+
+// writeBackup saves a snapshot of db into dir and removes all but the newest keep backups.
+// Zero keeps every backup.
+func writeBackup(db *buntdb.DB, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, backupPrefix+time.Now().Format(backupTimeLayout)+backupSuffix)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Save(f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	if keep > 0 {
+		backups, err := listBackups(dir)
+		if err != nil {
+			return path, err
+		}
+		for len(backups) > keep {
+			if err := os.Remove(filepath.Join(dir, backups[len(backups)-1])); err != nil {
+				return path, err
+			}
+			backups = backups[:len(backups)-1]
+		}
+	}
+	return path, nil
+}
+
+// listBackups returns the names of the backups in dir, newest first.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if _, err := backupTime(e.Name()); err == nil && e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// backupTime returns when the backup with the given file name was taken.
+func backupTime(name string) (time.Time, error) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, errors.New("not a backup: " + name)
+	}
+	return time.ParseInLocation(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix), time.Local)
+}
+
+// restoreBackup replaces the contents of the database file at path with the backup.
+// The backup is copied next to the file and swapped in, so the file is kept if that fails.
+func restoreBackup(backup, path string) error {
+	// make sure the backup can be loaded before overwriting anything
+	db, err := buntdb.Open(backup)
+	if err != nil {
+		return err
+	}
+	db.Close()
+
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// maxChoices is the most replies a single request can ask for.
+const maxChoices = 8
+
+var (
+	errNoChoices     = errors.New("the response has no choices")
+	errNoReplyChosen = errors.New("all replies were discarded")
+)
+
+// completeChoices sends a request which is not streamed and returns its response
+// with the choices in the order of their index.
+func completeChoices(ctx context.Context, r *Request) (*Response, error) {
+	resp, err := createChatCompletion(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var completion Response
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return nil, err
+	}
+	if len(completion.Choices) == 0 {
+		return nil, errNoChoices
+	}
+	sort.SliceStable(completion.Choices, func(i, j int) bool {
+		return completion.Choices[i].Index < completion.Choices[j].Index
+	})
+	return &completion, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var errNoClipboard = errors.New("no clipboard utility found, install xclip, xsel or wl-clipboard")
+
+// lookupCommand returns the first available command of the candidates for the current OS,
+// or nil if none is installed.
+func lookupCommand(candidates map[string][][]string) *exec.Cmd {
+	commands, ok := candidates[runtime.GOOS]
+	if !ok {
+		commands = candidates["linux"]
+	}
+
+	for _, c := range commands {
+		if path, err := exec.LookPath(c[0]); err == nil {
+			return exec.Command(path, c[1:]...)
+		}
+	}
+	return nil
+}
+
+var copyCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+	},
+}
+
+var pasteCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}},
+	"linux": {
+		{"wl-paste", "--no-newline"},
+		{"xclip", "-selection", "clipboard", "-o"},
+		{"xsel", "--clipboard", "--output"},
+	},
+}
+
+func writeClipboard(text string) error {
+	cmd := lookupCommand(copyCommands)
+	if cmd == nil {
+		return errNoClipboard
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+func readClipboard() (string, error) {
+	cmd := lookupCommand(pasteCommands)
+	if cmd == nil {
+		return "", errNoClipboard
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(out), "\r\n", "\n"), nil
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds user preferences. Values are read from ~/.chatgpt/config.json
+// and can be overridden by command line flags.
+type Config struct {
+	// ScrollToEnd scrolls a loaded conversation to its last message instead of its beginning.
+	ScrollToEnd bool `json:"scroll_to_end"`
+
+	// TypingInterval buffers streamed deltas and flushes them every TypingInterval milliseconds.
+	// Zero writes each delta as soon as it arrives.
+	TypingInterval int `json:"typing_interval_ms"`
+
+	// ContextWindow limits the number of past turns sent to the API. Zero sends the whole conversation.
+	ContextWindow int `json:"context_window"`
+
+	// Markdown formats assistant replies once they have been received.
+	Markdown bool `json:"markdown"`
+
+	// JSONMode asks the model to reply with a valid JSON object.
+	JSONMode bool `json:"json_mode"`
+
+	// Seed asks the model for reproducible replies. Nil leaves it to the API.
+	Seed *int `json:"seed,omitempty"`
+
+	// Temperature makes replies more random from 0 to 2, conversations can override it. Nil leaves it to the API.
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// PresencePenalty and FrequencyPenalty discourage repetition, from -2 to 2.
+	PresencePenalty  float64 `json:"presence_penalty"`
+	FrequencyPenalty float64 `json:"frequency_penalty"`
+
+	// MaxReplyTokens caps the tokens of each reply. A streaming reply then shows how much of it was used.
+	// Zero leaves it to the API.
+	MaxReplyTokens int `json:"max_tokens"`
+
+	// LockTimeout is how long to wait for another instance to release the history.
+	LockTimeout time.Duration `json:"-"`
+
+	// Force starts even if the history is locked by another instance.
+	Force bool `json:"-"`
+
+	// MessageSpacing is the number of blank lines between messages, from 0 to 3.
+	MessageSpacing int `json:"message_spacing"`
+
+	// ResumeStreams asks the model to continue a reply that was interrupted by a dropped connection.
+	// This sends the partial reply again and costs extra tokens.
+	ResumeStreams bool `json:"resume_streams"`
+
+	// ShowTimestamps prints the time of each message next to its header.
+	ShowTimestamps bool `json:"show_timestamps"`
+
+	// ShowSystem prints the system message at the top of each conversation.
+	ShowSystem bool `json:"show_system"`
+
+	// Transcript is a file every message is appended to as plain text while it is streamed.
+	Transcript string `json:"transcript"`
+
+	// UserAgent is sent with every API request, some gateways only let known clients through.
+	UserAgent string `json:"user_agent"`
+
+	// BackupInterval is the number of minutes between snapshots of the history in ~/.chatgpt/backups.
+	// Zero disables them.
+	BackupInterval int `json:"backup_interval_min"`
+
+	// BackupKeep is the number of snapshots kept, older ones are removed. Zero keeps all of them.
+	BackupKeep int `json:"backup_keep"`
+
+	// CompactOnExit rewrites the history on a clean exit without the space left behind by changed
+	// and deleted conversations, which buntdb only appends to.
+	CompactOnExit bool `json:"compact_on_exit"`
+
+	// CompactInterval is the number of minutes between compactions of the history. Zero disables them.
+	CompactInterval int `json:"compact_interval_min"`
+
+	// MaxTitleLength cuts suggested and edited titles to this many characters. Zero sets no limit.
+	MaxTitleLength int `json:"max_title_length"`
+
+	// TitleLanguage asks for titles in the given language, such as "English". Empty lets the model choose.
+	TitleLanguage string `json:"title_language"`
+
+	// ShowReasoning shows what reasoning models think before replying instead of a collapsed line.
+	ShowReasoning bool `json:"show_reasoning"`
+
+	// BaseURL and CompletionsPath make up the URL of the completions endpoint,
+	// other OpenAI compatible servers may use a different path.
+	BaseURL         string `json:"base_url"`
+	CompletionsPath string `json:"completions_path"`
+
+	// ModelsPath is the path under the base URL of the endpoint listing the available models.
+	ModelsPath string `json:"models_path"`
+
+	// QuestionHeight is the number of rows of the question area, including its border.
+	QuestionHeight int `json:"question_height"`
+
+	// Models are offered to regenerate a reply with.
+	Models []string `json:"models"`
+
+	// SuggestTitles asks the model for the title of a new chat. Otherwise the chat is named
+	// after the start of its first question, which saves an API call.
+	SuggestTitles bool `json:"suggest_titles"`
+
+	// TitleFrom is what suggested titles are based on: the first question of a chat, or its first reply
+	// which often sums up the topic better but leaves the chat unnamed until the reply arrived.
+	TitleFrom string `json:"title_from"`
+
+	// TitleMinMessages and TitleMinTokens defer suggesting the title of a new chat until it has this many
+	// messages, or this many tokens, so that it is named after more than a greeting. Until then it is
+	// named after its first question. Zero does not wait.
+	TitleMinMessages int `json:"title_min_messages"`
+	TitleMinTokens   int `json:"title_min_tokens"`
+
+	// SessionTokenCap and SessionRequestCap block further requests once a session used that many
+	// tokens or sent that many requests, until the user confirms going over. Zero sets no cap.
+	SessionTokenCap   int `json:"session_token_cap"`
+	SessionRequestCap int `json:"session_request_cap"`
+
+	// ConfirmTokens asks before sending a prompt of more than this many tokens. Zero never asks.
+	ConfirmTokens int `json:"confirm_tokens"`
+
+	// ConfirmModels asks before sending any prompt to one of these models.
+	ConfirmModels []string `json:"confirm_models"`
+
+	// Prices are the dollars a million prompt tokens cost for each model, to estimate what a request costs.
+	Prices map[string]float64 `json:"prices"`
+
+	// Logprobs asks for the probabilities of the tokens of each reply and shows how confident the model was.
+	Logprobs bool `json:"logprobs"`
+
+	// TopLogprobs is the number of most likely alternatives asked for each token, up to 20.
+	TopLogprobs int `json:"top_logprobs"`
+
+	// Choices asks for this many replies to each question at once to pick the best one from.
+	// They are not streamed. Zero or one streams a single reply.
+	Choices int `json:"choices"`
+
+	// FallbackModels are tried in order when a request fails before any of the reply was received.
+	FallbackModels []string `json:"fallback_models"`
+
+	// Encrypt stores conversations sealed with a key derived from a passphrase, which is read from
+	// CHATGPT_PASSPHRASE or asked at startup. Turning it off decrypts the history when it is next opened.
+	Encrypt bool `json:"encrypt"`
+
+	// DateFormat is the Go time layout of the date ctrl-t inserts into the question.
+	DateFormat string `json:"date_format"`
+
+	// PromptCache sends a cache key derived from the system message, or the first message without one,
+	// so that requests sharing that prefix are routed to where it is already cached and cost less.
+	PromptCache bool `json:"prompt_cache"`
+
+	// Mouse lets the panes be clicked and shows a button to stop a reply while it is requested.
+	Mouse bool `json:"mouse"`
+
+	// EscapeChain is the order Esc moves the focus in, among "question", "conversation", "history"
+	// and "search". Panes left out are not reached with Esc, and the last one leads back to the first.
+	EscapeChain []string `json:"escape_chain"`
+
+	// RTL reverses the words and letters of replies written in a right-to-left script, such as Arabic
+	// or Hebrew, for terminals which cannot show them. Terminals which do handle them should leave it off.
+	RTL bool `json:"rtl"`
+
+	// LiteralQuestions shows questions exactly as they were typed, with their tabs and brackets,
+	// instead of reading color tags in them.
+	LiteralQuestions bool `json:"literal_questions"`
+
+	// IdleLock hides the screen after this many minutes without a key being pressed, until the next key.
+	// Zero never locks it.
+	IdleLock int `json:"idle_lock_min"`
+
+	// Stop are up to four sequences at which the model stops replying, such as the end marker of a structured
+	// reply. The sequence itself is left out of the reply.
+	Stop []string `json:"stop"`
+
+	// TrashDays keeps deleted conversations in a trash for this many days, from which they can be restored.
+	// Zero deletes them at once. Deleting all conversations always does.
+	TrashDays int `json:"trash_days"`
+	// ArchiveDays archives the conversations not touched in this many days at startup, which hides them
+	// from the history until archived conversations are shown. Zero never archives them.
+	ArchiveDays int `json:"archive_days"`
+
+	// ModelColors are the colors of the label of replies by each model, such as "purple" or "#af87ff",
+	// to tell apart which model answered in a conversation. A model's color also applies to its versions.
+	ModelColors map[string]string `json:"model_colors"`
+
+	// PromptPrefix and PromptSuffix are put before and after every question sent, such as a reminder
+	// to always answer in British English. The conversation shows and stores the questions without them.
+	PromptPrefix string `json:"prompt_prefix"`
+	PromptSuffix string `json:"prompt_suffix"`
+
+	// Variables are referenced in questions as ${NAME} and replaced by their values when a question is sent.
+	Variables map[string]string `json:"variables"`
+
+	// TimeFormat is the Go time layout of the times shown in the history, the trash, the metadata and
+	// message headers, and of those in exports and their file names.
+	TimeFormat string `json:"time_format"`
+
+	// Templates seed new chats with example turns, which are sent before the first question to show
+	// the model what is expected. Chats are started from them with F10.
+	Templates map[string][]Message `json:"templates"`
+
+	// Personas are system prompts by name, which a conversation can switch to at any point.
+	Personas map[string]string `json:"personas"`
+
+	// LineNumbers numbers the lines of code blocks in formatted replies. It is toggled with l and remembered.
+	LineNumbers bool `json:"line_numbers"`
+
+	// Headers are sent with every chat completion request, such as those a proxy in front of the API needs.
+	Headers map[string]string `json:"headers"`
+
+	// QueueQuestions lets the next question be typed while a reply streams. Enter queues it,
+	// and it is sent once the reply finished.
+	QueueQuestions bool `json:"queue_questions"`
+
+	// SimilarQuestions warns before sending a question much like one asked in another conversation,
+	// which can be opened instead. It searches every question of the history on each submit.
+	SimilarQuestions bool `json:"similar_questions"`
+
+	// Follow shows this transcript read-only and follows the replies another instance streams into it,
+	// instead of opening the history.
+	Follow string `json:"-"`
+
+	// Import adds the conversations of this conversations.json, from the data export of ChatGPT,
+	// or the conversation in it copied as JSON, to the history instead of starting.
+	Import string `json:"-"`
+
+	// Offline echoes prompts back instead of calling the API.
+	Offline bool `json:"-"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		ScrollToEnd:     true,
+		MessageSpacing:  1,
+		LockTimeout:     1 * time.Second,
+		UserAgent:       defaultUserAgent(),
+		BackupInterval:  30,
+		BackupKeep:      10,
+		MaxTitleLength:  40,
+		BaseURL:         defaultBaseURL,
+		CompletionsPath: defaultCompletionsPath,
+		ModelsPath:      defaultModelsPath,
+		QuestionHeight:  5,
+		Models:          []string{gpt3Dot5Turbo, "gpt-4", "gpt-4o"},
+		SuggestTitles:   true,
+		TitleFrom:       titleFromQuestion,
+		DateFormat:      "Monday, 2 January 2006 15:04 MST",
+		TimeFormat:      defaultTimeLayout,
+		EscapeChain:     append([]string(nil), defaultEscapeChain...),
+		TrashDays:       30,
+		Prices: map[string]float64{
+			gpt3Dot5Turbo: 0.5,
+			"gpt-4":       30,
+			"gpt-4o":      2.5,
+		},
+	}
+}
+
+// loadConfig reads the config file at path on top of the defaults. A missing file is not an error.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// saveConfigValue sets key to value in the config file at path and keeps the other settings.
+func saveConfigValue(path, key string, value any) error {
+	settings := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return err
+		}
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	settings[key] = raw
+
+	data, err = json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.ScrollToEnd, "scroll-to-end", c.ScrollToEnd, "scroll to the end of a conversation when loading it")
+	fs.IntVar(&c.TypingInterval, "typing-interval", c.TypingInterval, "flush streamed replies every `ms` milliseconds (0 disables throttling)")
+	fs.IntVar(&c.ContextWindow, "context-window", c.ContextWindow, "send only the last `N` turns of a conversation (0 sends all)")
+	fs.BoolVar(&c.Markdown, "markdown", c.Markdown, "format assistant replies as markdown")
+	fs.BoolVar(&c.JSONMode, "json", c.JSONMode, "ask the model to reply with JSON")
+	fs.Func("seed", "`seed` for reproducible replies", func(s string) error {
+		seed, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		c.Seed = &seed
+		return nil
+	})
+	fs.Func("temperature", "`temperature` of replies between 0 and 2", func(s string) error {
+		t, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		c.Temperature = &t
+		return nil
+	})
+	fs.Float64Var(&c.PresencePenalty, "presence-penalty", c.PresencePenalty, "presence penalty between -2 and 2")
+	fs.Float64Var(&c.FrequencyPenalty, "frequency-penalty", c.FrequencyPenalty, "frequency penalty between -2 and 2")
+	fs.IntVar(&c.MaxReplyTokens, "max-tokens", c.MaxReplyTokens, "cap replies at `N` tokens (0 leaves it to the API)")
+	fs.DurationVar(&c.LockTimeout, "lock-timeout", c.LockTimeout, "how long to wait for another instance to release the history")
+	fs.BoolVar(&c.Force, "force", c.Force, "start even if another instance holds the history lock")
+	fs.IntVar(&c.MessageSpacing, "message-spacing", c.MessageSpacing, "number of blank lines between messages (0-3)")
+	fs.BoolVar(&c.ResumeStreams, "resume-streams", c.ResumeStreams, "continue replies interrupted by a dropped connection (costs extra tokens)")
+	fs.BoolVar(&c.ShowTimestamps, "timestamps", c.ShowTimestamps, "show the time of each message")
+	fs.BoolVar(&c.ShowSystem, "show-system", c.ShowSystem, "show the system message at the top of each conversation")
+	fs.StringVar(&c.Transcript, "transcript", c.Transcript, "append every message to the plain text file at `path`")
+	fs.StringVar(&c.UserAgent, "user-agent", c.UserAgent, "User-Agent header sent with API requests")
+	fs.IntVar(&c.BackupInterval, "backup-interval", c.BackupInterval, "back up the history every `minutes` (0 disables backups)")
+	fs.IntVar(&c.BackupKeep, "backup-keep", c.BackupKeep, "number of backups to keep (0 keeps all)")
+	fs.BoolVar(&c.CompactOnExit, "compact-on-exit", c.CompactOnExit, "compact the history when quitting")
+	fs.IntVar(&c.CompactInterval, "compact-interval", c.CompactInterval, "compact the history every `minutes` (0 disables it)")
+	fs.IntVar(&c.MaxTitleLength, "max-title-length", c.MaxTitleLength, "cut titles to `N` characters (0 sets no limit)")
+	fs.StringVar(&c.TitleLanguage, "title-language", c.TitleLanguage, "`language` of suggested titles")
+	fs.BoolVar(&c.ShowReasoning, "show-reasoning", c.ShowReasoning, "show the reasoning of replies from reasoning models")
+	fs.StringVar(&c.BaseURL, "base-url", c.BaseURL, "base `URL` of the API")
+	fs.StringVar(&c.CompletionsPath, "completions-path", c.CompletionsPath, "`path` of the chat completions endpoint under the base URL")
+	fs.StringVar(&c.ModelsPath, "models-path", c.ModelsPath, "`path` of the endpoint listing the models under the base URL")
+	fs.IntVar(&c.QuestionHeight, "question-height", c.QuestionHeight, "number of `rows` of the question area")
+	fs.BoolVar(&c.SuggestTitles, "suggest-titles", c.SuggestTitles, "ask the model for the title of a new chat")
+	fs.StringVar(&c.TitleFrom, "title-from", c.TitleFrom, "suggest titles from the first question or reply of a chat")
+	fs.IntVar(&c.TitleMinMessages, "title-min-messages", c.TitleMinMessages, "suggest the title of a chat once it has `N` messages")
+	fs.IntVar(&c.TitleMinTokens, "title-min-tokens", c.TitleMinTokens, "suggest the title of a chat once it has `N` tokens")
+	fs.IntVar(&c.SessionTokenCap, "token-cap", c.SessionTokenCap, "ask before sending more requests once a session used `N` tokens (0 sets no cap)")
+	fs.IntVar(&c.SessionRequestCap, "request-cap", c.SessionRequestCap, "ask before sending more than `N` requests in a session (0 sets no cap)")
+	fs.IntVar(&c.ConfirmTokens, "confirm-tokens", c.ConfirmTokens, "ask before sending a prompt of more than `N` tokens (0 never asks)")
+	fs.BoolVar(&c.Logprobs, "logprobs", c.Logprobs, "show how confident the model was of each reply")
+	fs.IntVar(&c.TopLogprobs, "top-logprobs", c.TopLogprobs, "number of likely alternatives to ask for each token (0-20)")
+	fs.IntVar(&c.Choices, "choices", c.Choices, "ask for `N` replies to each question and pick one (not streamed)")
+	fs.BoolVar(&c.Encrypt, "encrypt", c.Encrypt, "encrypt the history with a passphrase")
+	fs.StringVar(&c.DateFormat, "date-format", c.DateFormat, "Go time `layout` of the date inserted with ctrl-t")
+	fs.BoolVar(&c.PromptCache, "prompt-cache", c.PromptCache, "send a cache key so that the system message can be cached")
+	fs.BoolVar(&c.Mouse, "mouse", c.Mouse, "enable the mouse and a button to stop replies")
+	fs.Func("escape-chain", "comma separated `panes` Esc moves between", func(s string) error {
+		c.EscapeChain = strings.Split(s, ",")
+		return nil
+	})
+	fs.BoolVar(&c.RTL, "rtl", c.RTL, "reverse replies written from right to left for terminals which cannot show them")
+	fs.BoolVar(&c.LiteralQuestions, "literal-questions", c.LiteralQuestions, "show questions exactly as typed, such as pasted code")
+	fs.IntVar(&c.IdleLock, "idle-lock", c.IdleLock, "hide the screen after `minutes` without input (0 never does)")
+	// the sequences given as flags replace those of the config file
+	stopFlags := false
+	fs.Func("stop", "`sequence` at which replies stop, can be repeated", func(s string) error {
+		if !stopFlags {
+			c.Stop = nil
+			stopFlags = true
+		}
+		c.Stop = append(c.Stop, s)
+		return nil
+	})
+	fs.IntVar(&c.TrashDays, "trash-days", c.TrashDays, "keep deleted conversations in the trash for `N` days (0 deletes them at once)")
+	fs.IntVar(&c.ArchiveDays, "archive-days", c.ArchiveDays, "archive conversations not touched in `N` days at startup (0 never does)")
+	fs.StringVar(&c.PromptPrefix, "prompt-prefix", c.PromptPrefix, "text put before every question sent")
+	fs.StringVar(&c.PromptSuffix, "prompt-suffix", c.PromptSuffix, "text put after every question sent, such as a reminder")
+	fs.Func("var", "define the prompt variable `NAME=value`, can be repeated", func(s string) error {
+		name, value, ok := parseVariable(s)
+		if !ok {
+			return errors.New("want NAME=value")
+		}
+		if c.Variables == nil {
+			c.Variables = make(map[string]string)
+		}
+		c.Variables[name] = value
+		return nil
+	})
+	fs.StringVar(&c.TimeFormat, "time-format", c.TimeFormat, "Go time `layout` of the times shown in the history and exports")
+	fs.BoolVar(&c.LineNumbers, "line-numbers", c.LineNumbers, "number the lines of code blocks")
+	fs.Func("header", "send the header `Name: value` with chat completion requests, can be repeated", func(s string) error {
+		name, value, err := parseHeader(s)
+		if err != nil {
+			return err
+		}
+		if c.Headers == nil {
+			c.Headers = make(map[string]string)
+		}
+		c.Headers[name] = value
+		return nil
+	})
+	fs.BoolVar(&c.QueueQuestions, "queue", c.QueueQuestions, "queue the question typed while a reply streams and send it once it finished")
+	fs.BoolVar(&c.SimilarQuestions, "similar", c.SimilarQuestions, "warn before sending a question much like one asked in another conversation")
+	fs.StringVar(&c.Follow, "follow", c.Follow, "only follow the transcript at `path` which another instance writes")
+	fs.StringVar(&c.Import, "import", c.Import, "import the conversations.json at `path` from the data export of ChatGPT, or a conversation copied as JSON, and exit")
+	fs.BoolVar(&c.Offline, "offline", c.Offline, "echo prompts back without calling the API")
+}
+
+// maxTemperature is the highest temperature the API accepts, the most random.
+const maxTemperature = 2
+
+// maxStopSequences is the number of stop sequences the API accepts.
+const maxStopSequences = 4
+
+// promptCacheKeyLength is the number of hex digits of the hash kept in a prompt cache key.
+const promptCacheKeyLength = 16
+
+// newRequest builds a chat completion request of model for messages from the current settings.
+// A temperature other than nil replaces the one of the config.
+func (c *Config) newRequest(model string, temperature *float64, messages []Message, stream bool) *Request {
+	r := &Request{
+		Model:    model,
+		Messages: make([]Message, len(messages)),
+		Stream:   stream,
+	}
+	// the API rejects fields it does not know, so only send what it needs
+	for i, m := range messages {
+		r.Messages[i] = Message{Role: m.Role, Content: m.Content}
+		// questions are stored as typed, so every one of them is wrapped, not only the new one
+		if m.Role == roleUser {
+			r.Messages[i].Content = c.wrapPrompt(m.Content)
+		}
+	}
+	if c.JSONMode {
+		r.ResponseFormat = &ResponseFormat{Type: responseFormatJSON}
+	}
+	r.Seed = c.Seed
+	if temperature == nil {
+		temperature = c.Temperature
+	}
+	if temperature != nil {
+		t := clamp(*temperature, 0, maxTemperature)
+		r.Temperature = &t
+	}
+	r.PresencePenalty = clamp(c.PresencePenalty, -2, 2)
+	r.FrequencyPenalty = clamp(c.FrequencyPenalty, -2, 2)
+	if c.MaxReplyTokens > 0 {
+		r.MaxTokens = c.MaxReplyTokens
+	}
+	// only replies which are not streamed can come as several choices
+	if !stream && c.Choices > 1 {
+		r.N = int(clamp(float64(c.Choices), 1, maxChoices))
+	}
+	if c.Logprobs {
+		r.Logprobs = true
+		r.TopLogprobs = int(clamp(float64(c.TopLogprobs), 0, maxTopLogprobs))
+	}
+	if len(c.Stop) > maxStopSequences {
+		r.Stop = c.Stop[:maxStopSequences]
+	} else {
+		r.Stop = c.Stop
+	}
+	if c.PromptCache {
+		r.PromptCacheKey = promptCacheKey(messages)
+	}
+	return r
+}
+
+// promptCacheKey names the static start of messages: the leading system messages,
+// or the first message if there are none.
+func promptCacheKey(messages []Message) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, m := range messages {
+		if m.Role != roleSystem {
+			break
+		}
+		h.Write([]byte(m.Content))
+	}
+	if messages[0].Role != roleSystem {
+		h.Write([]byte(messages[0].Content))
+	}
+	return "chatgpt-tui-" + hex.EncodeToString(h.Sum(nil))[:promptCacheKeyLength]
+}
+
+// wrapPrompt puts the prompt prefix and suffix around the question content, each on lines of its own.
+func (c *Config) wrapPrompt(content string) string {
+	if c.PromptPrefix != "" {
+		content = c.PromptPrefix + "\n\n" + content
+	}
+	if c.PromptSuffix != "" {
+		content += "\n\n" + c.PromptSuffix
+	}
+	return content
+}
+
+// completionsURL joins the base URL and the completions path.
+func (c *Config) completionsURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(c.CompletionsPath, "/")
+}
+
+// modelsURL joins the base URL and the models path.
+func (c *Config) modelsURL() string {
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(c.ModelsPath, "/")
+}
+
+// titlePrompt asks the model to suggest a title for a conversation starting with content.
+func (c *Config) titlePrompt(content string) string {
+	if c.TitleLanguage == "" {
+		return prefixSuggestTitle + content
+	}
+	return fmt.Sprintf(prefixSuggestTitleIn, c.TitleLanguage) + content
+}
+
+// deferTitles reports whether suggesting the title of a new chat waits until it is long enough.
+func (c *Config) deferTitles() bool {
+	return c.SuggestTitles && (c.TitleMinMessages > 0 || c.TitleMinTokens > 0)
+}
+
+// titleDue reports whether a chat with the given numbers of messages and tokens is long enough to be titled.
+func (c *Config) titleDue(messages, tokens int) bool {
+	return c.TitleMinMessages > 0 && messages >= c.TitleMinMessages ||
+		c.TitleMinTokens > 0 && tokens >= c.TitleMinTokens
+}
+
+// What suggested titles are based on.
+const (
+	titleFromQuestion = "question"
+	titleFromReply    = "reply"
+)
+
+// truncateTitle cuts title to MaxTitleLength characters.
+func (c *Config) truncateTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if r := []rune(title); c.MaxTitleLength > 0 && len(r) > c.MaxTitleLength {
+		title = strings.TrimSpace(string(r[:c.MaxTitleLength]))
+	}
+	return title
+}
+
+// needsConfirm reports whether to ask before sending a prompt of tokens to model.
+func (c *Config) needsConfirm(model string, tokens int) bool {
+	if c.ConfirmTokens > 0 && tokens > c.ConfirmTokens {
+		return true
+	}
+	for _, m := range c.ConfirmModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// promptCost estimates the dollars a prompt of tokens costs with model.
+// It reports false if the price of model is unknown.
+func (c *Config) promptCost(model string, tokens int) (float64, bool) {
+	price, ok := c.Prices[model]
+	return price * float64(tokens) / 1000000, ok
+}
+
+const (
+	minQuestionHeight = 3
+	maxQuestionHeight = 30
+)
+
+func clampQuestionHeight(height int) int {
+	return int(clamp(float64(height), minQuestionHeight, maxQuestionHeight))
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
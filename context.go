@@ -0,0 +1,33 @@
+package main
+
+// trimContext keeps the leading system messages, the pinned and example messages, the last persona switch
+// and the last turns user turns (a user message together with the replies that follow it). Zero keeps everything.
+func trimContext(messages []Message, turns int) []Message {
+	if turns <= 0 {
+		return messages
+	}
+
+	var system []Message
+	for len(messages) > 0 && messages[0].Role == roleSystem {
+		system = append(system, messages[0])
+		messages = messages[1:]
+	}
+
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0 && turns > 0; i-- {
+		if messages[i].Role == roleUser {
+			start = i
+			turns--
+		}
+	}
+
+	persona := latestPersona(messages)
+	trimmed := make([]Message, 0, len(system)+len(messages)-start)
+	trimmed = append(trimmed, system...)
+	for i, m := range messages[:start] {
+		if m.Pinned || m.Example || i == persona {
+			trimmed = append(trimmed, m)
+		}
+	}
+	return append(trimmed, messages[start:]...)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/term"
+)
+
+// encryptionMetaKey holds what is needed to derive the key of an encrypted history.
+const encryptionMetaKey = metaKeyPrefix + "encryption"
+
+// passphraseEnv is read for the passphrase of an encrypted history before asking for it.
+const passphraseEnv = "CHATGPT_PASSPHRASE"
+
+const (
+	keyIterations = 600000
+	keySize       = 32
+	saltSize      = 16
+	// passphraseCheck is sealed with the key to tell a wrong passphrase from a damaged history.
+	passphraseCheck = "chatgpt-tui"
+)
+
+var errWrongPassphrase = errors.New("wrong passphrase")
+
+// encryptionMeta is stored under encryptionMetaKey.
+type encryptionMeta struct {
+	Salt  []byte `json:"salt"`
+	Check []byte `json:"check"`
+}
+
+// sealedConversation is how a conversation is stored in an encrypted history.
+// Its time stays readable so that the time index keeps working, and is sealed along with
+// the conversation so that it cannot be changed unnoticed.
+type sealedConversation struct {
+	Time   int64  `json:"time"`
+	Sealed []byte `json:"sealed"`
+}
+
+// codec turns conversations into database values and back.
+// With a nil aead the history is stored in plain text, otherwise it is sealed with AES-GCM.
+// Each value is sealed together with its key and time, so that it cannot be moved to another
+// title unnoticed.
+type codec struct {
+	aead cipher.AEAD
+}
+
+func (c *codec) encrypted() bool {
+	return c.aead != nil
+}
+
+// sealedWith is the additional data a conversation stored under key is sealed with.
+func sealedWith(key string, time int64) []byte {
+	return []byte(key + "\x00" + strconv.FormatInt(time, 10))
+}
+
+// encode returns the value conv is stored with under key.
+func (c *codec) encode(key string, conv *Conversation) (string, error) {
+	data, err := json.Marshal(conv)
+	if err != nil || !c.encrypted() {
+		return string(data), err
+	}
+
+	data, err = json.Marshal(sealedConversation{Time: conv.Time, Sealed: c.seal(data, sealedWith(key, conv.Time))})
+	return string(data), err
+}
+
+// decode returns the conversation stored with value under key.
+func (c *codec) decode(key, value string) (*Conversation, error) {
+	data := []byte(value)
+	if c.encrypted() {
+		var sealed sealedConversation
+		if err := json.Unmarshal(data, &sealed); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = c.open(sealed.Sealed, sealedWith(key, sealed.Time)); err != nil {
+			return nil, err
+		}
+	}
+
+	var conv *Conversation
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// seal encrypts data behind a random nonce, authenticating additional along with it.
+func (c *codec) seal(data, additional []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return c.aead.Seal(nonce, nonce, data, additional)
+}
+
+func (c *codec) open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, errors.New("sealed value too short")
+	}
+	n := c.aead.NonceSize()
+	return c.aead.Open(nil, sealed[:n], sealed[n:], additional)
+}
+
+func newCodec(passphrase string, salt []byte) (*codec, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, keyIterations, keySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &codec{aead: aead}, nil
+}
+
+// loadCodec returns the codec the history in db is stored with,
+// asking for the passphrase if it is encrypted.
+func loadCodec(db *buntdb.DB, passphrase func(create bool) (string, error)) (*codec, error) {
+	var value string
+	err := db.View(func(tx *buntdb.Tx) error {
+		var err error
+		value, err = tx.Get(encryptionMetaKey)
+		return err
+	})
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return &codec{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta encryptionMeta
+	if err := json.Unmarshal([]byte(value), &meta); err != nil {
+		return nil, err
+	}
+	p, err := passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newCodec(p, meta.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := c.open(meta.Check, []byte(encryptionMetaKey)); err != nil || string(check) != passphraseCheck {
+		return nil, errWrongPassphrase
+	}
+	return c, nil
+}
+
+// setEncryption rewrites every conversation in db, the trash included, when encryption is turned on
+// or off and returns the codec the history is then stored with.
+func setEncryption(db *buntdb.DB, current *codec, encrypt bool, passphrase func(create bool) (string, error)) (*codec, error) {
+	if current.encrypted() == encrypt {
+		return current, nil
+	}
+
+	next := &codec{}
+	var meta []byte
+	if encrypt {
+		p, err := passphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		if next, err = newCodec(p, salt); err != nil {
+			return nil, err
+		}
+		if meta, err = json.Marshal(encryptionMeta{Salt: salt, Check: next.seal([]byte(passphraseCheck), []byte(encryptionMetaKey))}); err != nil {
+			return nil, err
+		}
+	}
+
+	err := db.Update(func(tx *buntdb.Tx) error {
+		updates := make(map[string]string)
+		var failed error
+		err := tx.Ascend("", func(key, value string) bool {
+			var err error
+			switch {
+			case isTrashKey(key):
+				value, err = recodeTrashed(strings.TrimPrefix(key, trashKeyPrefix), value, current, next)
+			case isMetaKey(key):
+				return true
+			default:
+				var c *Conversation
+				if c, err = current.decode(key, value); err == nil {
+					value, err = next.encode(key, c)
+				}
+			}
+			if err != nil {
+				failed = fmt.Errorf("%q: %w", key, err)
+				return false
+			}
+			updates[key] = value
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if failed != nil {
+			return failed
+		}
+
+		for key, value := range updates {
+			if _, _, err := tx.Set(key, value, nil); err != nil {
+				return err
+			}
+		}
+		if encrypt {
+			_, _, err = tx.Set(encryptionMetaKey, string(meta), nil)
+		} else {
+			_, err = tx.Delete(encryptionMetaKey)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// readPassphrase asks for the passphrase on the terminal without echoing it,
+// twice when it is set for the first time.
+func readPassphrase(create bool) (string, error) {
+	fmt.Fprint(os.Stderr, "Passphrase of the history: ")
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", errors.New("the passphrase is empty")
+	}
+
+	if create {
+		fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+		again, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(again) != string(p) {
+			return "", errors.New("the passphrases do not match")
+		}
+	}
+	return string(p), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tidwall/buntdb"
+)
+
+// openDB opens the history database, migrates it to the current schema and creates its indexes.
+// Its conversations are encrypted or decrypted to match encrypt, passphrase is asked only if needed.
+func openDB(path string, encrypt bool, passphrase func(create bool) (string, error)) (*buntdb.DB, *codec, error) {
+	db, err := buntdb.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := loadCodec(db, passphrase)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	if err := migrate(db, c); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	next, err := setEncryption(db, c, encrypt, passphrase)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if next != c {
+		// the values from before the change stay in the file until it is rewritten
+		if err := db.Shrink(); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		c = next
+	}
+
+	if err := db.CreateIndex("time", "*", buntdb.IndexJSON("time")); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, c, nil
+}
+
+// compactDB rewrites the file of db at path without the values since changed or deleted, which buntdb
+// leaves behind. It returns the sizes of the file before and after, in bytes. The file is written
+// next to the old one and swapped in, so the old one is kept if that fails.
+func compactDB(db *buntdb.DB, path string) (before, after int64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = info.Size()
+	if err := db.Shrink(); err != nil {
+		return before, 0, err
+	}
+	if info, err = os.Stat(path); err != nil {
+		return before, 0, err
+	}
+	return before, info.Size(), nil
+}
+
+// formatSize returns n bytes in the largest unit it is at least one of.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
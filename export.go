@@ -0,0 +1,278 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// exportHTMLPage lays out an exported conversation as a standalone page, styles included.
+var exportHTMLPage = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 50rem; margin: 2rem auto; padding: 0 1rem; background: #f6f7f9; color: #1f2328; font: 16px/1.5 system-ui, sans-serif; }
+header { margin-bottom: 2rem; }
+h1 { margin: 0; font-size: 1.5rem; }
+.meta, .note { color: #656d76; font-size: .875rem; }
+.note { white-space: pre-wrap; }
+.message { margin: 1rem 0; padding: .75rem 1rem; border-radius: 1rem; max-width: 85%; overflow-wrap: anywhere; }
+.user { margin-left: auto; background: #dbeafe; border-bottom-right-radius: .25rem; }
+.assistant { background: #fff; border: 1px solid #d0d7de; border-bottom-left-radius: .25rem; }
+.system, .tool { max-width: 100%; background: none; color: #656d76; font-style: italic; }
+.role { font-weight: 600; font-size: .875rem; }
+.role small { font-weight: normal; color: #656d76; }
+.content p { margin: .5rem 0; }
+.content h2, .content h3, .content h4 { margin: .75rem 0 .25rem; font-size: 1rem; }
+code { font: .875em ui-monospace, monospace; background: rgba(175, 184, 193, .2); padding: .1em .3em; border-radius: .3em; }
+pre { background: #1f2328; color: #e6edf3; padding: .75rem 1rem; border-radius: .5rem; overflow-x: auto; }
+pre code { background: none; padding: 0; }
+.lang { display: block; color: #8b949e; font-size: .75rem; margin-bottom: .25rem; }
+.kw { color: #ff7b72; }
+.str { color: #a5d6ff; }
+.num { color: #79c0ff; }
+.com { color: #8b949e; font-style: italic; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<div class="meta">{{.Model}} · {{.Time}}</div>
+{{if .Note}}<div class="note">{{.Note}}</div>{{end}}
+</header>
+{{range .Messages}}<div class="message {{.Role}}">
+<div class="role">{{.Name}}{{if .Model}} <small>{{.Model}}</small>{{end}}{{if .Time}} <small>{{.Time}}</small>{{end}}</div>
+<div class="content">{{.Content}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+type exportedMessage struct {
+	Role    string
+	Name    string
+	Model   string
+	Time    string
+	Content template.HTML
+}
+
+// exportHTML writes c as a standalone HTML page to dir, named after title, and returns its path.
+func exportHTML(dir, title string, c *Conversation) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	messages := make([]exportedMessage, 0, len(c.Messages))
+	for _, msg := range c.Messages {
+		exported := exportedMessage{
+			Role:    msg.Role,
+			Name:    roleName(msg.Role),
+			Model:   msg.Model,
+			Content: template.HTML(markdownToHTML(msg.Content)),
+		}
+		if msg.Time != 0 {
+			exported.Time = formatTime(time.Unix(msg.Time, 0))
+		}
+		messages = append(messages, exported)
+	}
+
+	// the scratch chat has no time of its own
+	at := time.Now()
+	if c.Time != 0 {
+		at = time.Unix(c.Time, 0)
+	}
+
+	path := filepath.Join(dir, exportFileName(title, at))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	err = exportHTMLPage.Execute(f, map[string]any{
+		"Title":    title,
+		"Model":    conversationModel(c),
+		"Time":     formatTime(at),
+		"Note":     c.Note,
+		"Messages": messages,
+	})
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// roleName is how a role is called in exports and transcripts.
+func roleName(role string) string {
+	switch role {
+	case roleUser:
+		return "You"
+	case roleAssistant:
+		return "ChatGPT"
+	case "":
+		return "Unknown"
+	default:
+		return strings.ToUpper(role[:1]) + role[1:]
+	}
+}
+
+var reUnsafeFileName = regexp.MustCompile(`[^\w.-]+`)
+
+// exportFileName names the export of the conversation title as of at, in a way which is safe on every system.
+func exportFileName(title string, at time.Time) string {
+	name := strings.Trim(reUnsafeFileName.ReplaceAllString(title, "-"), "-.")
+	if name == "" {
+		name = "conversation"
+	}
+	return name + "_" + strings.Trim(reUnsafeFileName.ReplaceAllString(formatTime(at), "-"), "-.") + ".html"
+}
+
+// markdownToHTML converts the markdown of a message into HTML, escaping everything else.
+// Fenced code blocks are highlighted, the rest gets paragraphs, headings, lists and inline styles.
+func markdownToHTML(text string) string {
+	var (
+		sb        strings.Builder
+		paragraph []string
+		list      []string
+	)
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			sb.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = nil
+		}
+	}
+	flushList := func() {
+		if len(list) > 0 {
+			sb.WriteString("<ul>\n<li>" + strings.Join(list, "</li>\n<li>") + "</li>\n</ul>\n")
+			list = nil
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushParagraph()
+			flushList()
+			fence := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, "`"))]
+			lang := strings.TrimSpace(trimmed[len(fence):])
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), fence) {
+				end++
+			}
+			sb.WriteString("<pre>")
+			if lang != "" {
+				sb.WriteString(`<span class="lang">` + html.EscapeString(lang) + "</span>")
+			}
+			// an unclosed block runs to the end of the message
+			code := lines[i+1:]
+			if end < len(lines) {
+				code = lines[i+1 : end]
+			}
+			sb.WriteString("<code>" + highlightCode(strings.Join(code, "\n")) + "</code></pre>\n")
+			i = end
+		case trimmed == "":
+			flushParagraph()
+			flushList()
+		case reHeading.MatchString(trimmed):
+			flushParagraph()
+			flushList()
+			match := reHeading.FindStringSubmatch(trimmed)
+			level := len(match[1]) + 1
+			if level > 4 {
+				level = 4
+			}
+			tag := "h" + string(rune('0'+level))
+			sb.WriteString("<" + tag + ">" + inlineHTML(match[2]) + "</" + tag + ">\n")
+		case reListItem.MatchString(line):
+			flushParagraph()
+			list = append(list, inlineHTML(reListItem.FindStringSubmatch(line)[2]))
+		default:
+			flushList()
+			paragraph = append(paragraph, inlineHTML(line))
+		}
+	}
+	flushParagraph()
+	flushList()
+	return sb.String()
+}
+
+// inlineHTML escapes line and styles its inline code, bold text and links.
+func inlineHTML(line string) string {
+	parts := reInlineCode.Split(line, -1)
+	codes := reInlineCode.FindAllStringSubmatch(line, -1)
+	var sb strings.Builder
+	for i, part := range parts {
+		last := 0
+		for _, span := range reURL.FindAllStringIndex(part, -1) {
+			link := strings.TrimRight(part[span[0]:span[1]], linkTrailing)
+			sb.WriteString(boldHTML(part[last:span[0]]))
+			sb.WriteString(`<a href="` + html.EscapeString(link) + `">` + html.EscapeString(link) + "</a>")
+			last = span[0] + len(link)
+		}
+		sb.WriteString(boldHTML(part[last:]))
+		if i < len(codes) {
+			sb.WriteString("<code>" + html.EscapeString(codes[i][1]) + "</code>")
+		}
+	}
+	return sb.String()
+}
+
+func boldHTML(text string) string {
+	return reBold.ReplaceAllString(html.EscapeString(text), "<strong>$1</strong>")
+}
+
+// reCodeToken finds the comments, strings, numbers and words of code in most languages.
+var reCodeToken = regexp.MustCompile(`(?P<com>//[^\n]*|/\*[\s\S]*?\*/|#[^\n]*)|(?P<str>"(?:\\.|[^"\\\n])*"|'(?:\\.|[^'\\\n])*'|` + "`[^`]*`" + `)|(?P<num>\b\d+(?:\.\d+)?\b)|(?P<word>\b[A-Za-z_]\w*\b)`)
+
+// codeKeywords are highlighted in code blocks whatever their language.
+var codeKeywords = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`if else elif for while do return func function def class import from package
+		var let const type struct interface switch case default break continue go defer select chan map range
+		true false nil null None True False try catch except finally raise throw new delete public private
+		protected static void in not and or is with as yield async await lambda fn mut impl pub use mod match
+		enum trait self this super extends implements then fi done esac echo local export`) {
+		codeKeywords[kw] = true
+	}
+}
+
+// highlightCode escapes code and wraps its tokens in spans styled by the export page.
+func highlightCode(code string) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range reCodeToken.FindAllStringSubmatchIndex(code, -1) {
+		sb.WriteString(html.EscapeString(code[last:match[0]]))
+		token := code[match[0]:match[1]]
+		class := ""
+		for i, name := range reCodeToken.SubexpNames() {
+			if i > 0 && match[2*i] >= 0 {
+				class = name
+				break
+			}
+		}
+		if class == "word" {
+			class = ""
+			if codeKeywords[token] {
+				class = "kw"
+			}
+		}
+		if class == "" {
+			sb.WriteString(html.EscapeString(token))
+		} else {
+			sb.WriteString(`<span class="` + class + `">` + html.EscapeString(token) + "</span>")
+		}
+		last = match[1]
+	}
+	sb.WriteString(html.EscapeString(code[last:]))
+	return sb.String()
+}
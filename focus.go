@@ -0,0 +1,55 @@
+package main
+
+// Panes which Esc moves between.
+const (
+	paneQuestion     = "question"
+	paneConversation = "conversation"
+	paneHistory      = "history"
+	paneSearch       = "search"
+)
+
+// defaultEscapeChain leads from the question out to the search.
+var defaultEscapeChain = []string{paneQuestion, paneConversation, paneHistory, paneSearch}
+
+// escapeChain returns the known panes of chain in order and once each,
+// or the default chain if it names none of them.
+func escapeChain(chain []string) []string {
+	known := make(map[string]bool, len(defaultEscapeChain))
+	for _, pane := range defaultEscapeChain {
+		known[pane] = true
+	}
+
+	panes := make([]string, 0, len(chain))
+	for _, pane := range chain {
+		if known[pane] {
+			panes = append(panes, pane)
+			known[pane] = false
+		}
+	}
+	if len(panes) == 0 {
+		return defaultEscapeChain
+	}
+	return panes
+}
+
+// nextPane returns the pane Esc moves to from the given one along chain, passing over the panes
+// which cannot be focused. The last pane leads back to the first, so that pressing Esc always
+// comes back around to where it started. It reports false if from is not in the chain.
+func nextPane(chain []string, from string, focusable func(pane string) bool) (string, bool) {
+	current := -1
+	for i, pane := range chain {
+		if pane == from {
+			current = i
+		}
+	}
+	if current < 0 {
+		return "", false
+	}
+
+	for n := 1; n < len(chain); n++ {
+		if next := chain[(current+n)%len(chain)]; focusable(next) {
+			return next, true
+		}
+	}
+	return "", false
+}
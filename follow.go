@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// followPollInterval is how often a followed transcript is checked for what was appended to it.
+	followPollInterval = 200 * time.Millisecond
+	// followBacklog is how much of the end of a transcript is shown when following it starts, in bytes.
+	followBacklog = 64 << 10
+)
+
+// followTranscript shows the transcript at path read-only and follows the replies another instance
+// streams into it, like a mirror of its conversation, until q, Esc or ctrl-c is pressed.
+// It opens neither the history nor the API, so it runs next to the instance writing the transcript.
+func followTranscript(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	app := tview.NewApplication()
+	view := tview.NewTextView().
+		SetWordWrap(true).
+		SetScrollable(true)
+	view.SetTitle("Following " + path).SetBorder(true)
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC || event.Rune() == 'q' {
+			app.Stop()
+			return nil
+		}
+		return event
+	})
+
+	// start towards the end, a long transcript would take a while to show
+	var offset int64
+	if info, err := f.Stat(); err == nil && info.Size() > followBacklog {
+		offset = info.Size() - followBacklog
+	}
+	go func() {
+		for {
+			info, err := f.Stat()
+			if err != nil {
+				app.Stop()
+				return
+			}
+			size := info.Size()
+			// the transcript was truncated, start over
+			if size < offset {
+				offset = 0
+				app.QueueUpdateDraw(func() {
+					view.Clear()
+				})
+			}
+			if size > offset {
+				data := make([]byte, size-offset)
+				n, _ := f.ReadAt(data, offset)
+				offset += int64(n)
+				app.QueueUpdateDraw(func() {
+					view.Write(data[:n])
+					view.ScrollToEnd()
+				})
+			}
+			time.Sleep(followPollInterval)
+		}
+	}()
+
+	return app.SetRoot(view, true).Run()
+}
@@ -9,6 +9,8 @@ require (
 	github.com/pkoukk/tiktoken-go v0.1.1
 	github.com/rivo/tview v0.0.0-20230320095235-84f9c0ff9de8
 	github.com/tidwall/buntdb v1.2.10
+	golang.org/x/crypto v0.6.0
+	golang.org/x/term v0.5.0
 )
 
 require (
@@ -26,6 +28,5 @@ require (
 	github.com/tidwall/rtred v0.1.2 // indirect
 	github.com/tidwall/tinyqueue v0.1.1 // indirect
 	golang.org/x/sys v0.6.0 // indirect
-	golang.org/x/term v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+)
+
+// chatGPTConversation is a conversation in the conversations.json of the data export of ChatGPT.
+// Its messages form a tree, since editing a question or regenerating a reply branches it.
+type chatGPTConversation struct {
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	UpdateTime       float64                `json:"update_time"`
+	Mapping          map[string]chatGPTNode `json:"mapping"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+}
+
+type chatGPTNode struct {
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string `json:"content_type"`
+		// Parts are strings for text, and objects for images and other attachments.
+		Parts []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// text returns the text parts of the message, which are all that can be imported.
+// Code run by the model, its results and other kinds of content are left out.
+func (msg *chatGPTMessage) text() string {
+	if msg.Content.ContentType != "text" && msg.Content.ContentType != "multimodal_text" {
+		return ""
+	}
+	parts := make([]string, 0, len(msg.Content.Parts))
+	for _, raw := range msg.Content.Parts {
+		var part string
+		if json.Unmarshal(raw, &part) == nil && part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// branch returns the nodes from the root of the tree to the current node, which is the branch
+// last shown in ChatGPT. Without a current node, the last child is followed from the root.
+// Other branches are left out.
+func (e *chatGPTConversation) branch() []chatGPTNode {
+	leaf := e.CurrentNode
+	if _, ok := e.Mapping[leaf]; !ok {
+		leaf = ""
+		for id, node := range e.Mapping {
+			if _, ok := e.Mapping[node.Parent]; !ok {
+				leaf = id
+				break
+			}
+		}
+		for seen := make(map[string]bool); leaf != "" && !seen[leaf]; {
+			seen[leaf] = true
+			children := e.Mapping[leaf].Children
+			if len(children) == 0 {
+				break
+			}
+			leaf = children[len(children)-1]
+		}
+	}
+
+	var nodes []chatGPTNode
+	// a broken export could link the nodes in a loop
+	for seen := make(map[string]bool); leaf != "" && !seen[leaf]; {
+		seen[leaf] = true
+		node, ok := e.Mapping[leaf]
+		if !ok {
+			break
+		}
+		nodes = append(nodes, node)
+		leaf = node.Parent
+	}
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
+
+// knownModel returns slug if it is one of models, or else an empty model so that the default applies.
+// ChatGPT names its models differently from the API, such as "auto" or "text-davinci-002-render-sha".
+func knownModel(models []string, slug string) string {
+	for _, m := range models {
+		if m == slug {
+			return slug
+		}
+	}
+	return ""
+}
+
+// conversation flattens the branch last shown into the messages of a conversation. Messages which
+// are hidden, empty, not text, or neither from the user nor the assistant, such as tool calls, are left out.
+// The conversation keeps the model it was last replied by only if it is one of models.
+func (e *chatGPTConversation) conversation(models []string) *Conversation {
+	c := &Conversation{Time: int64(e.UpdateTime), Model: knownModel(models, e.DefaultModelSlug)}
+	if c.Time == 0 {
+		c.Time = int64(e.CreateTime)
+	}
+	for _, node := range e.branch() {
+		msg := node.Message
+		if msg == nil || msg.Metadata.Hidden {
+			continue
+		}
+		if msg.Author.Role != roleUser && msg.Author.Role != roleAssistant {
+			continue
+		}
+		content := msg.text()
+		if content == "" {
+			continue
+		}
+		if model := knownModel(models, msg.Metadata.ModelSlug); msg.Author.Role == roleAssistant && model != "" {
+			c.Model = model
+		}
+		c.Messages = append(c.Messages, Message{Role: msg.Author.Role, Content: content, Time: int64(msg.CreateTime)})
+	}
+	return c
+}
+
+// sameStart reports whether a and b start with the same message, as a conversation imported twice does.
+func sameStart(a, b *Conversation) bool {
+	return len(a.Messages) > 0 && len(b.Messages) > 0 &&
+		a.Messages[0].Content == b.Messages[0].Content && a.Messages[0].Time == b.Messages[0].Time
+}
+
+// sharedConversation turns the messages of a conversation copied as JSON into a conversation
+// named after its first question.
+func sharedConversation(messages []Message) (string, *Conversation) {
+	c := &Conversation{Messages: messages}
+	title := ""
+	for _, msg := range messages {
+		if msg.Time > c.Time {
+			c.Time = msg.Time
+		}
+		if title == "" && msg.Role == roleUser {
+			title, _, _ = strings.Cut(strings.TrimSpace(msg.Content), "\n")
+		}
+	}
+	return title, c
+}
+
+// importChatGPT adds the conversations of the conversations.json at path, from the data export of
+// ChatGPT, to the history, or the conversation at path copied as JSON. A title which is taken gets
+// a number, unless it is the same conversation imported before, which is skipped. It returns how many
+// conversations were imported and skipped. Titles are cut to the configured length and the models
+// ChatGPT replied with are kept only if they are among the configured ones.
+func importChatGPT(db *buntdb.DB, dbCodec *codec, path string, cfg *Config) (imported, skipped int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var exported []chatGPTConversation
+	if err := json.Unmarshal(data, &exported); err != nil {
+		return 0, 0, err
+	}
+	titles := make([]string, len(exported))
+	convs := make([]*Conversation, len(exported))
+	for i := range exported {
+		titles[i], convs[i] = exported[i].Title, exported[i].conversation(cfg.Models)
+	}
+	// messages copied with J have roles instead of a tree
+	if len(exported) > 0 && exported[0].Mapping == nil {
+		var messages []Message
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return 0, 0, err
+		}
+		title, c := sharedConversation(messages)
+		titles, convs = []string{title}, []*Conversation{c}
+	}
+
+	err = db.Update(func(tx *buntdb.Tx) error {
+		for i, c := range convs {
+			if len(c.Messages) == 0 {
+				skipped++
+				continue
+			}
+			title := cfg.truncateTitle(titles[i])
+			if title == "" || isMetaKey(title) {
+				title = "Untitled"
+			}
+
+			duplicate := false
+			for {
+				value, err := tx.Get(title)
+				if err == buntdb.ErrNotFound {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if existing, err := dbCodec.decode(title, value); err == nil && sameStart(existing, c) {
+					duplicate = true
+					break
+				}
+				title = addSuffixNumber(title)
+			}
+			if duplicate {
+				skipped++
+				continue
+			}
+
+			value, err := dbCodec.encode(title, c)
+			if err != nil {
+				return err
+			}
+			if _, _, err := tx.Set(title, value, nil); err != nil {
+				return err
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return imported, skipped, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	contextGlobal       = "Global"
+	contextHistory      = "History"
+	contextConversation = "Conversation"
+	contextSelection    = "Message selection"
+	contextQuestion     = "Question"
+)
+
+// keyContexts lists the contexts in the order they are shown in the help.
+var keyContexts = []string{contextGlobal, contextHistory, contextConversation, contextSelection, contextQuestion}
+
+type keybinding struct {
+	context string
+	// key is shown in the help; keys which share a binding are separated by a slash,
+	// and the later ones have the modifier of the first if they have none.
+	key         string
+	description string
+	// footer shows the binding in the help line at the bottom of the screen.
+	footer bool
+}
+
+// keybindings documents every key handled by the input captures in main,
+// which pass on the keys which are not listed for their context.
+var keybindings = []keybinding{
+	{contextGlobal, "F1", "new chat", true},
+	{contextGlobal, "F2", "history", true},
+	{contextGlobal, "F3", "conversation", true},
+	{contextGlobal, "F4", "question", true},
+	{contextGlobal, "F5", "new chat from clipboard", false},
+	{contextGlobal, "F6", "toggle JSON mode", false},
+	{contextGlobal, "F7", "toggle scratch chat (never saved)", false},
+	{contextGlobal, "F8", "pick the model of new chats", false},
+	{contextGlobal, "F9", "switch to a recent conversation", false},
+	{contextGlobal, "F10", "new chat from a template", false},
+	{contextGlobal, "tab/shift-tab", "cycle history/conversation/question, backwards with shift", false},
+	{contextGlobal, "ctrl-up/down", "grow/shrink the question", false},
+	{contextGlobal, "ctrl-s", "search", true},
+	{contextGlobal, "ctrl-g", "repeat the last search", false},
+	{contextGlobal, "ctrl-r", "reload history from disk", false},
+	{contextGlobal, "?", "help", true},
+	{contextGlobal, "ctrl-c", "quit", true},
+
+	{contextHistory, "j/k", "down/up", true},
+	{contextHistory, "enter", "open conversation", false},
+	{contextHistory, "e", "edit title", true},
+	{contextHistory, "d", "delete", true},
+	{contextHistory, "t", "restore a deleted conversation from the trash", false},
+	{contextHistory, "a", "archive or restore a conversation", false},
+	{contextHistory, "A", "show or hide archived conversations", false},
+	{contextHistory, "D", "delete all conversations and empty the trash", false},
+	{contextHistory, "b", "restore a backup", false},
+	{contextHistory, "c", "compact the history file", false},
+	{contextHistory, "i", "metadata", false},
+	{contextHistory, "w", "word and token statistics", false},
+	{contextHistory, "x", "export as HTML", false},
+	{contextHistory, "X", "export all conversations as HTML", false},
+	{contextHistory, "f", "open the folder of the history in the file manager", false},
+	{contextHistory, "n", "edit the note of a conversation", false},
+	{contextHistory, "s", "cycle sort order", false},
+	{contextHistory, "esc", "next pane of the escape chain", false},
+
+	{contextConversation, "v", "select a message", false},
+	{contextConversation, "u", "select the first question which got no reply", false},
+	{contextConversation, "i", "metadata", false},
+	{contextConversation, "w", "word and token statistics", false},
+	{contextConversation, "x", "export as HTML", false},
+	{contextConversation, "J", "copy the messages as JSON", false},
+	{contextConversation, "m", "toggle markdown rendering", false},
+	{contextConversation, "s", "toggle the system message", false},
+	{contextConversation, "c", "toggle showing only the latest exchange", false},
+	{contextConversation, "n", "toggle showing the newest exchange first", false},
+	{contextConversation, "P", "switch the persona of the conversation", false},
+	{contextConversation, "r", "toggle the reasoning of replies", false},
+	{contextConversation, "l", "toggle numbering the lines of code blocks", false},
+	{contextConversation, "R", "toggle reversing replies written from right to left", false},
+	{contextConversation, "f", "toggle following streaming replies to their end", false},
+	{contextConversation, "g", "regenerate the last reply with another model", false},
+	{contextConversation, "t", "suggest a title from the conversation and edit it", false},
+	{contextConversation, "ctrl-f/b", "page down/up", true},
+	{contextConversation, "enter", "question", false},
+	{contextConversation, "esc", "next pane of the escape chain", false},
+
+	{contextSelection, "j/k", "next/previous message", false},
+	{contextSelection, "down/up", "next/previous message", false},
+	{contextSelection, "enter", "copy message", false},
+	{contextSelection, "o", "open a link of the message", false},
+	{contextSelection, "p", "pin the message so that it is always sent", false},
+	{contextSelection, "z", "fold the reply to its first line, or expand it", false},
+	{contextSelection, "b", "branch a new conversation from the reply", false},
+	{contextSelection, "esc", "leave selection", false},
+
+	{contextQuestion, "enter", "submit", true},
+	{contextQuestion, "ctrl-t", "insert the current date and time", false},
+	{contextQuestion, "ctrl-o", "attach a file as a code block", false},
+	{contextQuestion, "alt-`", "insert a code block, around the selection if any", false},
+	{contextQuestion, "ctrl-p", "paste the clipboard at the cursor", false},
+	{contextQuestion, "ctrl-n", "open the conversation with a similar question", false},
+	{contextQuestion, "esc", "next pane of the escape chain", false},
+}
+
+// keyNames names the keys other than runes as the keybindings do.
+var keyNames = map[tcell.Key]string{
+	tcell.KeyF1:      "F1",
+	tcell.KeyF2:      "F2",
+	tcell.KeyF3:      "F3",
+	tcell.KeyF4:      "F4",
+	tcell.KeyF5:      "F5",
+	tcell.KeyF6:      "F6",
+	tcell.KeyF7:      "F7",
+	tcell.KeyF8:      "F8",
+	tcell.KeyF9:      "F9",
+	tcell.KeyF10:     "F10",
+	tcell.KeyTab:     "tab",
+	tcell.KeyBacktab: "shift-tab",
+	tcell.KeyEnter:   "enter",
+	tcell.KeyESC:     "esc",
+	tcell.KeyUp:      "up",
+	tcell.KeyDown:    "down",
+	tcell.KeyCtrlB:   "ctrl-b",
+	tcell.KeyCtrlC:   "ctrl-c",
+	tcell.KeyCtrlF:   "ctrl-f",
+	tcell.KeyCtrlG:   "ctrl-g",
+	tcell.KeyCtrlN:   "ctrl-n",
+	tcell.KeyCtrlO:   "ctrl-o",
+	tcell.KeyCtrlP:   "ctrl-p",
+	tcell.KeyCtrlR:   "ctrl-r",
+	tcell.KeyCtrlS:   "ctrl-s",
+	tcell.KeyCtrlT:   "ctrl-t",
+}
+
+// keyName names the key of event as the keybindings do, "d", "alt-`" or "ctrl-up" for example.
+func keyName(event *tcell.EventKey) string {
+	if event.Key() == tcell.KeyRune {
+		if event.Modifiers()&tcell.ModAlt != 0 {
+			return "alt-" + string(event.Rune())
+		}
+		return string(event.Rune())
+	}
+
+	name, ok := keyNames[event.Key()]
+	if ok && event.Modifiers()&tcell.ModCtrl != 0 && !strings.HasPrefix(name, "ctrl-") {
+		name = "ctrl-" + name
+	}
+	return name
+}
+
+// keys returns the keys which share b.
+func (b keybinding) keys() []string {
+	keys := strings.Split(b.key, "/")
+	modifier, _, ok := strings.Cut(keys[0], "-")
+	if !ok {
+		return keys
+	}
+	for i := 1; i < len(keys); i++ {
+		if !strings.Contains(keys[i], "-") {
+			keys[i] = modifier + "-" + keys[i]
+		}
+	}
+	return keys
+}
+
+// boundKeys holds the keys of every context which are listed in the keybindings.
+var boundKeys = func() map[string]map[string]bool {
+	bound := make(map[string]map[string]bool, len(keyContexts))
+	for _, b := range keybindings {
+		if bound[b.context] == nil {
+			bound[b.context] = make(map[string]bool)
+		}
+		for _, key := range b.keys() {
+			bound[b.context][key] = true
+		}
+	}
+	return bound
+}()
+
+// boundKey returns the name of the key of event if it is listed for context in the keybindings,
+// or "" if it is not and must be passed on.
+func boundKey(context string, event *tcell.EventKey) string {
+	if name := keyName(event); boundKeys[context][name] {
+		return name
+	}
+	return ""
+}
+
+// footerHelp returns the one line help shown at the bottom of the screen.
+func footerHelp() string {
+	items := make([]string, 0)
+	for _, b := range keybindings {
+		if b.footer {
+			items = append(items, fmt.Sprintf("%s: %s", b.key, b.description))
+		}
+	}
+	return strings.Join(items, ", ")
+}
+
+// fullHelp returns every keybinding grouped by context.
+func fullHelp() string {
+	var sb strings.Builder
+	for _, context := range keyContexts {
+		fmt.Fprintf(&sb, "[yellow::b]%s[-::-]\n", context)
+		for _, b := range keybindings {
+			if b.context == context {
+				fmt.Fprintf(&sb, "  [green::]%-14s[-] %s\n", b.key, b.description)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// reURL matches web links in messages. Brackets are excluded so that links never swallow style tags.
+var reURL = regexp.MustCompile(`https?://[^\s<>"'\x60()\[\]]+`)
+
+// linkTrailing is the punctuation which ends a sentence rather than a link.
+const linkTrailing = ".,;:!?*"
+
+var errNoOpener = errors.New("no command found to open links, install xdg-utils")
+
+var openCommands = map[string][][]string{
+	"darwin":  {{"open"}},
+	"windows": {{"rundll32", "url.dll,FileProtocolHandler"}},
+	"linux":   {{"xdg-open"}, {"wslview"}},
+}
+
+var errNoFileManager = errors.New("no command found to open the file manager, install xdg-utils")
+
+var revealCommands = map[string][][]string{
+	"darwin":  {{"open"}},
+	"windows": {{"explorer"}},
+	"linux":   {{"xdg-open"}},
+}
+
+// findLinks returns the distinct links in text, in order of appearance.
+func findLinks(text string) []string {
+	links := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, link := range reURL.FindAllString(text, -1) {
+		link = strings.TrimRight(link, linkTrailing)
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// underlineLinks marks the links in text so that they stand out in the conversation.
+func underlineLinks(text string) string {
+	return reURL.ReplaceAllStringFunc(text, func(link string) string {
+		trimmed := strings.TrimRight(link, linkTrailing)
+		return "[::u]" + trimmed + "[::-]" + link[len(trimmed):]
+	})
+}
+
+// openLink opens link in the default browser without waiting for it.
+func openLink(link string) error {
+	cmd := lookupCommand(openCommands)
+	if cmd == nil {
+		return errNoOpener
+	}
+	cmd.Args = append(cmd.Args, link)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// revealDir shows dir in the file manager without waiting for it.
+func revealDir(dir string) error {
+	cmd := lookupCommand(revealCommands)
+	if cmd == nil {
+		return errNoFileManager
+	}
+	cmd.Args = append(cmd.Args, dir)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Logprobs are the log probabilities of the tokens of a reply, sent when the request asks for them.
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	// TopLogprobs are the most likely tokens at this position, including the chosen one.
+	TopLogprobs []TopLogprob `json:"top_logprobs,omitempty"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+const (
+	maxTopLogprobs = 20
+	// leastConfidentTokens is the number of tokens named in the confidence of a reply,
+	// each with at most maxAlternatives other likely tokens.
+	leastConfidentTokens = 3
+	maxAlternatives      = 3
+)
+
+func probability(logprob float64) float64 {
+	return math.Exp(logprob)
+}
+
+// confidenceNote summarizes the token probabilities of a reply in a dim line:
+// the average probability and the tokens the model was least sure of, with their alternatives.
+// It is empty if the reply came without probabilities.
+func confidenceNote(logprobs []TokenLogprob) string {
+	if len(logprobs) == 0 {
+		return ""
+	}
+
+	var sum float64
+	for _, lp := range logprobs {
+		sum += probability(lp.Logprob)
+	}
+
+	least := append([]TokenLogprob(nil), logprobs...)
+	sort.SliceStable(least, func(i, j int) bool {
+		return least[i].Logprob < least[j].Logprob
+	})
+	if len(least) > leastConfidentTokens {
+		least = least[:leastConfidentTokens]
+	}
+
+	tokens := make([]string, 0, len(least))
+	for _, lp := range least {
+		token := fmt.Sprintf("%q %.0f%%", lp.Token, 100*probability(lp.Logprob))
+		alternatives := make([]string, 0, len(lp.TopLogprobs))
+		for _, top := range lp.TopLogprobs {
+			if top.Token != lp.Token && len(alternatives) < maxAlternatives {
+				alternatives = append(alternatives, fmt.Sprintf("%q %.0f%%", top.Token, 100*probability(top.Logprob)))
+			}
+		}
+		if len(alternatives) > 0 {
+			token += " (or " + strings.Join(alternatives, ", ") + ")"
+		}
+		tokens = append(tokens, token)
+	}
+
+	note := fmt.Sprintf("confidence %.0f%%, least sure of %s", 100*sum/float64(len(logprobs)), strings.Join(tokens, ", "))
+	return "\n" + dimmed(tview.Escape(note))
+}
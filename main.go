@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -28,35 +28,91 @@ const (
 	roleSystem    = "system"
 	roleUser      = "user"
 	roleAssistant = "assistant"
+	roleTool      = "tool"
 
 	systemMessage = "You are ChatGPT, a large language model trained by OpenAI. Answer as concisely as possible."
 
-	prefixSuggestTitle = "suggest me a short title for "
+	prefixSuggestTitle   = "suggest me a short title for "
+	prefixSuggestTitleIn = "suggest me a short title in %s for "
+	promptContinue       = "Your previous reply was cut off. Continue exactly where you left off, without repeating anything."
+
+	pageMain           = "main"
+	pageEditTitle      = "editTitle"
+	pageDeleteTitle    = "deleteTitle"
+	pageOverwriteTitle = "overwriteTitle"
+	pageMetadata       = "metadata"
+	pageHelp           = "help"
+	pageDeleteAll      = "deleteAll"
+	pageConfirmAll     = "confirmDeleteAll"
+	pageBackups        = "backups"
+	pageRestore        = "restore"
+	pageStats          = "stats"
+	pagePicker         = "picker"
+	pageNote           = "note"
+	pageCap            = "cap"
+	pageConfirm        = "confirm"
+	pageChoices        = "choices"
+	pageAttach         = "attach"
+	pageFence          = "fence"
+	pageLock           = "lock"
+	pageTemperature    = "temperature"
+	pageLimit          = "limit"
+
+	maxPickerHeight = 20
+	stopButtonWidth = 8
+
+	// titlePlaceholder stands in the history for the title of a new chat until it is known
+	titlePlaceholder = "…"
+
+	// idleLockCheck is how often the screen checks whether it was idle for long enough to lock
+	idleLockCheck = 5 * time.Second
+
+	buttonCancel    = "Cancel"
+	buttonDelete    = "Delete"
+	buttonRestore   = "Restore"
+	buttonOK        = "OK"
+	buttonGoOver    = "Continue anyway"
+	buttonSend      = "Send"
+	buttonOverwrite = "Overwrite"
 
-	pageMain        = "main"
-	pageEditTitle   = "editTitle"
-	pageDeleteTitle = "deleteTitle"
+	maxTokens = 4097
 
-	buttonCancel = "Cancel"
-	buttonDelete = "Delete"
+	// duplicateWindow is how soon after a question the same one is taken for a double submit and ignored.
+	duplicateWindow = 2 * time.Second
 
-	maxTokens = 4097
+	maxResumeAttempts = 2
+
+	scratchTitle = "[scratch]"
 )
 
 var errTimeout = errors.New("timeout")
 
 type Conversation struct {
 	Time     int64     `json:"time"`
+	Model    string    `json:"model,omitempty"`
 	Messages []Message `json:"messages"`
+	// ContextReset is set when the conversation was started over because the previous one exceeded the token limit.
+	ContextReset bool `json:"context_reset,omitempty"`
+	// Note is a free-form reminder of what the conversation is about.
+	Note string `json:"note,omitempty"`
+	// Viewed is when the conversation was last shown with its latest reply.
+	Viewed int64 `json:"viewed,omitempty"`
+	// ResponseID is what the API calls its last reply, to quote when reporting an issue.
+	ResponseID string `json:"response_id,omitempty"`
+	// Temperature overrides the one of the config for this conversation. Nil follows the config.
+	Temperature *float64 `json:"temperature,omitempty"`
+	// ContextLimit caps the tokens of the conversation before it is started over, to keep it cheap.
+	// Zero follows maxTokens.
+	ContextLimit int `json:"context_limit,omitempty"`
+	// Untitled is set while a chat is named after its first question, until it is long enough to be titled.
+	Untitled bool `json:"untitled,omitempty"`
+	// Archived conversations are left out of the history unless archived ones are shown, or searched for.
+	Archived bool `json:"archived,omitempty"`
+	// Folded are the indices of the replies folded to their first line in the view. They are not stored.
+	Folded map[int]bool `json:"-"`
 }
 
 func main() {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		fmt.Fprintln(os.Stderr, "Please set `OPENAI_API_KEY` environment variable. You can find your API key at https://platform.openai.com/account/api-keys.")
-		os.Exit(1)
-	}
-
 	home, err := homedir.Dir()
 	if err != nil {
 		log.Panic(err)
@@ -67,28 +123,113 @@ func main() {
 		log.Panic(err)
 	}
 
+	configFile := filepath.Join(dbPath, "config.json")
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+		os.Exit(1)
+	}
+	cfg.registerFlags(flag.CommandLine)
+	flag.Parse()
+
+	if cfg.Follow != "" {
+		if err := followTranscript(cfg.Follow); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to follow transcript:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.Offline {
+		createChatCompletion = offlineChatCompletion
+		listModels = offlineModels
+	}
+	if len(cfg.Headers) > 0 {
+		useRequestMiddleware(setHeaders(cfg.Headers))
+	}
+	createChatCompletion = withMiddleware(createChatCompletion)
+	userAgent = cfg.UserAgent
+	if cfg.TimeFormat != "" {
+		timeLayout = cfg.TimeFormat
+	}
+	completionsURL = cfg.completionsURL()
+	modelsURL = cfg.modelsURL()
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" && !cfg.Offline && cfg.Import == "" {
+		fmt.Fprintln(os.Stderr, "Please set `OPENAI_API_KEY` environment variable. You can find your API key at https://platform.openai.com/account/api-keys.")
+		os.Exit(1)
+	}
+
+	var tr *transcript
+	if cfg.Transcript != "" {
+		tr, err = openTranscript(cfg.Transcript)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open transcript:", err)
+			os.Exit(1)
+		}
+		defer tr.Close()
+	}
+
 	dbFile := filepath.Join(dbPath, "history.db")
-	f, err := os.OpenFile(dbFile, os.O_RDWR|os.O_CREATE, 0640)
+	backupDir := filepath.Join(dbPath, "backups")
+	exportDir := filepath.Join(dbPath, "exports")
+	// the lock is on a file of its own, as the history is swapped for a new file when it is compacted
+	lockFile := filepath.Join(dbPath, "history.lock")
+	f, err := os.OpenFile(lockFile, os.O_RDWR|os.O_CREATE, 0640)
 	if err != nil {
 		log.Panic(err)
 	}
 	defer f.Close()
 
-	if err := flock(f, 1*time.Second); err != nil {
-		if errors.Is(err, errTimeout) {
-			fmt.Println("Another process is already running.")
-		} else {
+	if err := flock(f, cfg.LockTimeout); err != nil {
+		switch {
+		case cfg.Force:
+			fmt.Fprintf(os.Stderr, "Warning: ignoring the lock on %s (%v), concurrent changes may be lost.\n", lockFile, err)
+		case errors.Is(err, errTimeout):
+			fmt.Println("Another process is already running. Wait longer with -lock-timeout or start anyway with -force.")
+			return
+		default:
 			fmt.Println(err)
+			return
 		}
-		return
 	}
 
-	db, err := buntdb.Open(dbFile)
+	// the passphrase of an encrypted history is asked once and kept to reload it
+	var passphrase string
+	askPassphrase := func(create bool) (string, error) {
+		if passphrase == "" {
+			passphrase = os.Getenv(passphraseEnv)
+		}
+		if passphrase == "" {
+			p, err := readPassphrase(create)
+			if err != nil {
+				return "", err
+			}
+			passphrase = p
+		}
+		return passphrase, nil
+	}
+	db, dbCodec, err := openDB(dbFile, cfg.Encrypt, askPassphrase)
 	if err != nil {
-		log.Panic(err)
+		fmt.Fprintln(os.Stderr, "Failed to open the history:", err)
+		os.Exit(1)
+	}
+	// db is replaced when the history is reloaded from disk
+	defer func() {
+		db.Close()
+	}()
+
+	if cfg.Import != "" {
+		imported, skipped, err := importChatGPT(db, dbCodec, cfg.Import, cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to import:", err)
+			db.Close()
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d conversations, skipped %d which were empty or imported before.\n", imported, skipped)
+		return
 	}
-	defer db.Close()
-	db.CreateIndex("time", "*", buntdb.IndexJSON("time"))
 
 	textArea := tview.NewTextArea()
 	textArea.SetTitle("Question").SetBorder(true)
@@ -96,8 +237,16 @@ func main() {
 	list := tview.NewList()
 	list.SetTitle("History").SetBorder(true)
 
+	searchInputField := tview.NewInputField()
+	searchInputField.SetTitle("Search")
+	searchInputField.
+		SetFieldWidth(50).
+		SetAcceptanceFunc(tview.InputFieldMaxLength(50))
+	searchInputField.SetBorder(true)
+
 	// tview.Styles.PrimitiveBackgroundColor = tcell.ColorDefault
-	app := tview.NewApplication()
+	app := tview.NewApplication().EnableMouse(cfg.Mouse)
+	pages := tview.NewPages()
 	textView := tview.NewTextView().
 		SetChangedFunc(func() {
 			app.Draw()
@@ -106,214 +255,1886 @@ func main() {
 		SetRegions(true).
 		SetWordWrap(true)
 	textView.SetTitle("Conversation").SetBorder(true)
-	textView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Key() {
-		case tcell.KeyESC:
-			app.SetFocus(list)
-		case tcell.KeyEnter:
-			app.SetFocus(textArea)
+
+	escapePanes := map[string]tview.Primitive{
+		paneQuestion:     textArea,
+		paneConversation: textView,
+		paneHistory:      list,
+		paneSearch:       searchInputField,
+	}
+	chain := escapeChain(cfg.EscapeChain)
+	// escape moves the focus from pane to the next pane of the escape chain which can be focused.
+	escape := func(pane string) {
+		next, ok := nextPane(chain, pane, func(pane string) bool {
+			switch pane {
+			case paneConversation:
+				return textView.GetText(false) != ""
+			case paneHistory:
+				return list.GetItemCount() > 0
+			}
+			return true
+		})
+		if ok {
+			app.SetFocus(escapePanes[next])
 		}
-		return event
-	})
+	}
 
 	var (
 		m         = make(map[string]*Conversation)
 		isNewChat = true
+		streaming bool
+		// detached is set when the view is switched away while a reply streams,
+		// the rest of the reply is then saved without being shown
+		detached bool
+		// follow scrolls to the end of replies as they stream
+		follow = true
+		render = renderOptions{
+			markdown:   cfg.Markdown,
+			spacing:    cfg.MessageSpacing,
+			timestamps: cfg.ShowTimestamps,
+			system:     cfg.ShowSystem,
+			reasoning:  cfg.ShowReasoning,
+			rtl:        cfg.RTL,
+			literal:    cfg.LiteralQuestions,
+
+			lineNumbers: cfg.LineNumbers,
+
+			modelColors: cfg.ModelColors,
+		}
+
+		// scratch is an in-memory conversation that is never saved to the database
+		scratch         bool
+		scratchMessages []Message
+		scratchReset    bool
+		scratchResponse string
+		scratchFolded   map[int]bool
 	)
-	list.SetSelectedFocusOnly(true)
-	db.View(func(tx *buntdb.Tx) error {
-		err := tx.Descend("time", func(key, value string) bool {
-			var c *Conversation
-			if err := json.Unmarshal([]byte(value), &c); err == nil {
-				m[key] = c
 
-				list.AddItem(key, "", rune(0), func() {
-					textView.SetText(toConversation(c.Messages))
-				})
-			}
-			return true
+	setScratch := func(on bool) {
+		scratch = on
+		if on {
+			textView.SetTitle("Conversation [yellow::]" + tview.Escape(scratchTitle) + "[-]")
+		} else {
+			textView.SetTitle("Conversation")
+		}
+	}
+
+	// saveConversation stores c under title in the database and in m.
+	saveConversation := func(title string, c *Conversation) error {
+		value, err := dbCodec.encode(title, c)
+		if err != nil {
+			return err
+		}
+
+		err = db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(title, value, nil)
+			return err
 		})
-		return err
-	})
+		if err != nil {
+			return err
+		}
+		m[title] = c
+		return nil
+	}
 
-	list.SetChangedFunc(func(index int, title string, secondaryText string, shortcut rune) {
-		if c, ok := m[title]; ok {
-			textView.SetText(toConversation(c.Messages))
+	// findItem returns the index of the list item with the given title, or -1.
+	findItem := func(title string) int {
+		for i := 0; i < list.GetItemCount(); i++ {
+			if t, _ := list.GetItemText(i); t == title {
+				return i
+			}
 		}
-	})
-	list.SetSelectedFunc(func(index int, title string, secondaryText string, shortcut rune) {
-		list.SetSelectedFocusOnly(false)
-		if c, ok := m[title]; ok {
-			textView.SetText(toConversation(c.Messages))
+		return -1
+	}
+	// refreshItem updates the secondary text of the list item with the given title.
+	refreshItem := func(title string) {
+		if i := findItem(title); i >= 0 {
+			list.SetItemText(i, title, historyItemText(m[title]))
+		}
+	}
+
+	// recent are the conversations shown in the session, the most recently shown first
+	var recent []string
+	// showConversation renders the conversation with the given title
+	// and scrolls it according to the config.
+	showConversation := func(title string) {
+		c, ok := m[title]
+		if !ok {
+			return
+		}
+
+		if streaming {
+			detached = true
+		}
+		recent = touchRecent(recent, title)
+		if isUnread(c) {
+			c.Viewed = c.Time
+			if err := saveConversation(title, c); err != nil {
+				log.Println(err)
+			}
+			refreshItem(title)
+		}
+
+		setScratch(false)
+		isNewChat = false
+		textView.SetText(renderConversation(c, render))
+		// the latest exchange is at the end, unless the newest come first
+		if cfg.ScrollToEnd != render.newestFirst {
+			textView.ScrollToEnd()
+		} else {
+			textView.ScrollToBeginning()
 		}
+	}
+
+	var (
+		sortBy            sortMode
+		systemFingerprint string
+		// rateLimit is the remaining quota reported with the last response
+		rateLimit string
+		usage     = &sessionUsage{tokenCap: cfg.SessionTokenCap, requestCap: cfg.SessionRequestCap}
+		// activeModel is the model new chats start with
+		activeModel = gpt3Dot5Turbo
+	)
+
+	statusBar := tview.NewTextView().SetDynamicColors(true).SetTextAlign(tview.AlignRight)
+	// updateStatus shows the persistent indicators in the status bar.
+	updateStatus := func() {
+		items := []string{fmt.Sprintf("sort: %s", sortBy)}
+		if activeModel != gpt3Dot5Turbo {
+			items = append(items, fmt.Sprintf("model: %s", activeModel))
+		}
+		if cfg.JSONMode {
+			items = append(items, "json")
+		}
+		if !follow {
+			items = append(items, "follow: off")
+		}
+		if cfg.Seed != nil {
+			items = append(items, fmt.Sprintf("seed: %d", *cfg.Seed))
+		}
+		if systemFingerprint != "" {
+			items = append(items, fmt.Sprintf("fp: %s", systemFingerprint))
+		}
+		if rateLimit != "" {
+			items = append(items, rateLimit)
+		}
+		if s := usage.String(); s != "" {
+			items = append(items, s)
+		}
+		statusBar.SetText(strings.Join(items, " | "))
+	}
+	// flash shows a transient message in the status bar.
+	flash := func(format string, a ...any) {
+		msg := fmt.Sprintf(format, a...)
+		statusBar.SetText(msg)
+		go func() {
+			time.Sleep(3 * time.Second)
+			app.QueueUpdateDraw(func() {
+				if statusBar.GetText(false) == msg {
+					updateStatus()
+				}
+			})
+		}()
+	}
+	updateStatus()
+	// the rate limits are shown as every response reports them
+	observeResponses(func(_ *Request, resp *http.Response, _ error) {
+		if resp == nil {
+			return
+		}
+		if limits, ok := parseRateLimits(resp.Header); ok {
+			app.QueueUpdateDraw(func() {
+				rateLimit = limits.String()
+				updateStatus()
+			})
+		}
+	})
 
-		textView.ScrollToEnd()
+	// cancelReply stops the request in flight, it is nil while there is none
+	var cancelReply context.CancelFunc
+	stopButton := tview.NewButton("Stop")
+	stopButton.SetSelectedFunc(func() {
+		if cancelReply != nil {
+			cancelReply()
+			flash("Stopped the reply")
+		}
 		app.SetFocus(textArea)
 	})
+	footer := tview.NewFlex().SetDirection(tview.FlexColumn)
+	// showStop shows the stop button next to the status bar while a reply is requested.
+	// It is only clickable, so it stays hidden without the mouse.
+	showStop := func(show bool) {
+		width := 0
+		if show && cfg.Mouse {
+			width = stopButtonWidth
+		}
+		footer.ResizeItem(stopButton, width, 0)
+	}
 
-	pages := tview.NewPages()
-	editTitleInputField := tview.NewInputField().
-		SetFieldWidth(40).
-		SetAcceptanceFunc(tview.InputFieldMaxLength(40))
+	// populating suppresses the list's changed func while setListItems rebuilds it.
+	var populating bool
+	// showArchived lists the archived conversations in the history along with the others.
+	var showArchived bool
+	// listed leaves the archived conversations out of titles unless they are shown.
+	listed := func(titles []string) []string {
+		if showArchived {
+			return titles
+		}
+		return activeTitles(titles, m)
+	}
+	// pendingTitle is the history item of a new chat while its title is suggested, "" while there is none.
+	var pendingTitle string
+	// setListItems replaces the history list with the given titles in the current sort order.
+	setListItems := func(titles []string) {
+		populating = true
+		defer func() {
+			populating = false
+		}()
+
+		sortTitles(titles, m, sortBy)
+		list.Clear()
+		if pendingTitle != "" {
+			list.AddItem(pendingTitle, "", rune(0), nil)
+		}
+		for _, title := range titles {
+			list.AddItem(title, historyItemText(m[title]), rune(0), nil)
+		}
+	}
+	// pendingItem returns the index of the history item of the new chat, or -1 if it is not in the list.
+	pendingItem := func() int {
+		if pendingTitle == "" {
+			return -1
+		}
+		for i := 0; i < list.GetItemCount(); i++ {
+			if text, _ := list.GetItemText(i); text == pendingTitle {
+				return i
+			}
+		}
+		return -1
+	}
+	// setPendingTitle shows title, as far as it is known, in the history item of the new chat.
+	setPendingTitle := func(title string) {
+		if pendingTitle == "" {
+			return
+		}
+		if title == "" {
+			title = titlePlaceholder
+		}
+		if i := pendingItem(); i >= 0 {
+			list.SetItemText(i, title, "")
+		}
+		pendingTitle = title
+	}
+	// removePending takes the history item of the new chat out of the list.
+	removePending := func() {
+		populating = true
+		if i := pendingItem(); i >= 0 {
+			list.RemoveItem(i)
+		}
+		pendingTitle = ""
+		populating = false
+	}
 
-	deleteTitleModal := tview.NewModal()
-	deleteTitleModal.AddButtons([]string{buttonCancel, buttonDelete})
+	// currentConversation returns the conversation shown in textView, if it has been saved.
+	// The scratch conversation is returned as a temporary copy titled scratchTitle.
+	currentConversation := func() (string, *Conversation) {
+		if scratch {
+			if len(scratchMessages) == 0 {
+				return "", nil
+			}
+			return scratchTitle, &Conversation{
+				Model:        gpt3Dot5Turbo,
+				Messages:     scratchMessages,
+				ContextReset: scratchReset,
+				ResponseID:   scratchResponse,
+				Folded:       scratchFolded,
+			}
+		}
+		// a new chat is not saved yet, even if a template or its first question is shown
+		if isNewChat || textView.GetText(false) == "" || list.GetItemCount() == 0 {
+			return "", nil
+		}
+		title, _ := list.GetItemText(list.GetCurrentItem())
+		return title, m[title]
+	}
 
-	modal := func(p tview.Primitive, currentRow int) tview.Primitive {
-		return tview.NewFlex().SetDirection(tview.FlexRow).
-			AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
-				AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
-					AddItem(nil, 4+(currentRow*2), 1, false).
-					AddItem(p, 1, 1, true).
-					AddItem(nil, 0, 1, false), 0, 1, true).
-				AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
-					AddItem(nil, 0, 1, false).
-					AddItem(nil, 5, 1, false), 0, 3, false), 0, 1, true).
-			AddItem(nil, 1, 1, false)
+	pickerList := tview.NewList().ShowSecondaryText(false)
+	pickerList.SetBorder(true)
+	// pick opens a list of items and passes the chosen one to picked.
+	// The focus goes back to returnFocus once the list is closed.
+	pick := func(title string, items []string, returnFocus tview.Primitive, picked func(item string)) {
+		pickerList.Clear()
+		pickerList.SetTitle(title)
+		width := 40
+		for i, item := range items {
+			item := item
+			shortcut := rune(0)
+			if i < 9 {
+				shortcut = rune('1' + i)
+			}
+			pickerList.AddItem(item, "", shortcut, func() {
+				pages.HidePage(pagePicker)
+				app.SetFocus(returnFocus)
+				picked(item)
+			})
+			if len(item)+8 > width {
+				width = len(item) + 8
+			}
+		}
+		pickerList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+			if event.Key() == tcell.KeyESC {
+				pages.HidePage(pagePicker)
+				app.SetFocus(returnFocus)
+				return nil
+			}
+			return event
+		})
+		// long lists scroll
+		height := len(items) + 2
+		if height > maxPickerHeight {
+			height = maxPickerHeight
+		}
+		pages.AddPage(pagePicker, center(pickerList, width, height), true, true)
+		app.SetFocus(pickerList)
+	}
+	// pickModel opens a list of the configured models and passes the chosen one to picked.
+	pickModel := func(title string, returnFocus tview.Primitive, picked func(model string)) {
+		if len(cfg.Models) == 0 {
+			flash("[yellow::]No models to choose from, set models in the config[-]")
+			return
+		}
+		pick(title, cfg.Models, returnFocus, picked)
 	}
 
-	searchInputField := tview.NewInputField()
-	searchInputField.SetTitle("Search")
-	searchInputField.
-		SetFieldWidth(50).
-		SetAcceptanceFunc(tview.InputFieldMaxLength(50))
-	searchInputField.SetBorder(true)
-	searchInputField.SetDoneFunc(func(key tcell.Key) {
-		switch key {
-		case tcell.KeyEnter:
-			titles := make([]string, 0, len(m))
-			db.View(func(tx *buntdb.Tx) error {
-				err := tx.Descend("time", func(key, value string) bool {
-					titles = append(titles, key)
-					return true
-				})
+	// showTrash lists the deleted conversations and restores the one picked.
+	// It is restored under another title if the one it had was taken since.
+	showTrash := func() {
+		items, err := listTrash(db)
+		if err != nil {
+			flash("[red::]%s[-]", err)
+			return
+		}
+		if len(items) == 0 {
+			flash("The trash is empty")
+			return
+		}
+
+		texts := make([]string, len(items))
+		titles := make(map[string]string, len(items))
+		for i, item := range items {
+			texts[i] = fmt.Sprintf("%s (deleted %s)", item.Title, formatTime(item.Deleted))
+			titles[texts[i]] = item.Title
+		}
+		pick("Trash, enter to restore", texts, list, func(text string) {
+			trashed := titles[text]
+			title := trashed
+			for m[title] != nil {
+				title = addSuffixNumber(title)
+			}
+
+			var c *Conversation
+			err := db.Update(func(tx *buntdb.Tx) error {
+				var err error
+				c, err = restoreFromTrash(tx, dbCodec, trashed, title)
 				return err
 			})
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				return
+			}
 
-			text := searchInputField.GetText()
-			if text != "" {
-				idx := make(index)
-				idx.add(titles)
-				r := idx.search(text)
-				list.Clear()
-				for _, i := range r {
-					list.AddItem(titles[i], "", rune(0), func() {
-						if c, ok := m[titles[i]]; ok {
-							textView.SetText(toConversation(c.Messages))
-						}
-					})
-				}
-			} else {
-				list.Clear()
-				for i := range titles {
-					list.AddItem(titles[i], "", rune(0), func() {
-						if c, ok := m[titles[i]]; ok {
-							textView.SetText(toConversation(c.Messages))
-						}
-					})
-				}
+			m[title] = c
+			all := make([]string, 0, len(m))
+			for t := range m {
+				all = append(all, t)
 			}
-			if list.GetItemCount() > 0 {
-				app.SetFocus(list)
+			setListItems(listed(all))
+			if i := findItem(title); i >= 0 {
+				list.SetCurrentItem(i)
+			}
+			showConversation(title)
+			flash("Restored %q", title)
+		})
+	}
+
+	// switchRecent offers the conversations shown most recently in the session, other than the current one.
+	switchRecent := func() {
+		current := ""
+		if !isNewChat && !scratch {
+			current, _ = list.GetItemText(list.GetCurrentItem())
+		}
+		titles := make([]string, 0, maxRecent)
+		for _, title := range recent {
+			if _, ok := m[title]; ok && title != current && len(titles) < maxRecent {
+				titles = append(titles, title)
 			}
 		}
-	})
+		if len(titles) == 0 {
+			flash("[yellow::]No other conversation was opened yet[-]")
+			return
+		}
+		pick("Recent conversations", titles, textArea, func(title string) {
+			if i := findItem(title); i >= 0 {
+				list.SetCurrentItem(i)
+			}
+			showConversation(title)
+		})
+	}
 
-	var hiddenItemCount int
-	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Key() {
-		case tcell.KeyESC:
-			app.SetFocus(searchInputField)
+	// availableModels caches the chat models listed by the API for the session.
+	var availableModels []string
+	// pickActiveModel lets the user choose the model of new chats among those the API lists,
+	// or among the configured models if they cannot be fetched.
+	pickActiveModel := func() {
+		returnFocus := app.GetFocus()
+		picked := func(model string) {
+			activeModel = model
+			updateStatus()
+			flash("New chats use %s", model)
+		}
+		if availableModels != nil {
+			pick("Model of new chats", availableModels, returnFocus, picked)
+			return
 		}
 
-		_, _, _, height := list.GetInnerRect()
+		flash("Fetching models...")
+		go func() {
+			models, err := listModels()
+			app.QueueUpdateDraw(func() {
+				if err != nil {
+					if len(cfg.Models) == 0 {
+						flash("[red::]%s[-]", err)
+						return
+					}
+					flash("[yellow::]%s, showing the configured models[-]", err)
+					models = cfg.Models
+				} else {
+					availableModels = models
+				}
+				pick("Model of new chats", models, returnFocus, picked)
+			})
+		}()
+	}
 
-		switch event.Rune() {
-		case 'j':
-			if list.GetCurrentItem() < list.GetItemCount() {
-				list.SetCurrentItem(list.GetCurrentItem() + 1)
+	var (
+		metadataReturnFocus tview.Primitive
+		metadataTitle       string
+	)
+	temperatureInputField := tview.NewInputField().SetLabel("Temperature (0-2, empty for the default): ").SetFieldWidth(6)
+	temperatureInputField.SetTitle("Temperature of the conversation").SetBorder(true)
+	limitInputField := tview.NewInputField().SetLabel("Tokens (empty for the default): ").SetFieldWidth(8)
+	limitInputField.SetTitle("Context limit of the conversation").SetBorder(true)
+
+	metadataView := tview.NewTextView().SetDynamicColors(true)
+	metadataView.SetTitle("Metadata (m: model, t: temperature, l: context limit, c: copy the response ID)").SetBorder(true)
+	metadataView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC || event.Rune() == 'i' {
+			pages.HidePage(pageMetadata)
+			app.SetFocus(metadataReturnFocus)
+			return nil
+		}
+		if event.Rune() == 'c' {
+			_, c := currentConversation()
+			if c == nil || c.ResponseID == "" {
+				flash("[yellow::]No response ID was stored with the conversation[-]")
+				return nil
+			}
+			if err := writeClipboard(c.ResponseID); err != nil {
+				flash("[red::]%s[-]", err)
+			} else {
+				flash("Copied %s to clipboard", c.ResponseID)
+			}
+			return nil
+		}
+		if event.Rune() == 'm' {
+			if scratch {
+				flash("[yellow::]The scratch chat always uses %s[-]", gpt3Dot5Turbo)
+				return nil
+			}
+			c, ok := m[metadataTitle]
+			if !ok {
+				return nil
 			}
 
-			if list.GetCurrentItem() >= height/2 {
-				hiddenItemCount = list.GetCurrentItem() + 1 - (height / 2)
+			pages.HidePage(pageMetadata)
+			pickModel("Model of the conversation", metadataReturnFocus, func(model string) {
+				changed := *c
+				changed.Model = model
+				if err := saveConversation(metadataTitle, &changed); err != nil {
+					flash("[red::]%s[-]", err)
+					return
+				}
+				flash("%q now uses %s", metadataTitle, model)
+			})
+			return nil
+		}
+		if event.Rune() == 't' {
+			if scratch {
+				flash("[yellow::]The scratch chat always uses the temperature of the config[-]")
+				return nil
 			}
-		case 'k':
-			if list.GetCurrentItem() > 0 {
-				list.SetCurrentItem(list.GetCurrentItem() - 1)
+			c, ok := m[metadataTitle]
+			if !ok {
+				return nil
 			}
 
-			if list.GetCurrentItem()+1 == hiddenItemCount {
-				hiddenItemCount--
+			text := ""
+			if c.Temperature != nil {
+				text = strconv.FormatFloat(*c.Temperature, 'g', -1, 64)
 			}
-		case 'e':
-			currentIndex := list.GetCurrentItem()
-			currentTitle, _ := list.GetItemText(currentIndex)
-			editTitleInputField.
-				SetText(currentTitle).
+			temperatureInputField.SetText(text).
 				SetDoneFunc(func(key tcell.Key) {
 					switch key {
 					case tcell.KeyESC:
-						pages.HidePage(pageEditTitle)
-						app.SetFocus(list)
+						pages.HidePage(pageTemperature)
+						app.SetFocus(metadataReturnFocus)
 					case tcell.KeyEnter:
-						newTitle := editTitleInputField.GetText()
-						if newTitle != currentTitle {
-							c, _ := json.Marshal(m[currentTitle])
-							if err == nil {
-								db.Update(func(tx *buntdb.Tx) error {
-									_, _, err := tx.Set(newTitle, string(c), nil)
-									if err != nil {
-										return err
-									}
-
-									tx.Delete(currentTitle)
-
-									m[newTitle] = m[currentTitle]
-									delete(m, currentTitle)
-
-									list.RemoveItem(currentIndex)
-									list.InsertItem(currentIndex, newTitle, "", rune(0), nil)
-									list.SetCurrentItem(currentIndex)
-
-									return nil
-								})
+						changed := *c
+						changed.Temperature = nil
+						if text := strings.TrimSpace(temperatureInputField.GetText()); text != "" {
+							t, err := strconv.ParseFloat(text, 64)
+							if err != nil || t < 0 || t > maxTemperature {
+								flash("[yellow::]Enter a temperature between 0 and %d, or nothing for the default[-]", maxTemperature)
+								return
 							}
+							changed.Temperature = &t
+						}
+						pages.HidePage(pageTemperature)
+						app.SetFocus(metadataReturnFocus)
+						if err := saveConversation(metadataTitle, &changed); err != nil {
+							flash("[red::]%s[-]", err)
+							return
+						}
+						if changed.Temperature == nil {
+							flash("%q now uses the default temperature", metadataTitle)
+						} else {
+							flash("%q now uses a temperature of %g", metadataTitle, *changed.Temperature)
 						}
-						pages.HidePage(pageEditTitle)
-						app.SetFocus(list)
 					}
-				}).
-				SetBorder(false)
-			pages.AddPage(pageEditTitle, modal(editTitleInputField, list.GetCurrentItem()-hiddenItemCount), true, false)
-			pages.ShowPage(pageEditTitle)
-		case 'd':
-			currentIndex := list.GetCurrentItem()
-			currentTitle, _ := list.GetItemText(currentIndex)
-
-			deleteTitleModal.SetText(fmt.Sprintf("Are you sure you want to delete \"%s\"?", currentTitle)).
-				SetFocus(0).
-				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-					switch buttonLabel {
-					case buttonCancel:
-						pages.HidePage(pageDeleteTitle)
-						app.SetFocus(list)
-
-					case buttonDelete:
-						list.RemoveItem(currentIndex)
+				})
+			pages.HidePage(pageMetadata)
+			pages.ShowPage(pageTemperature)
+			app.SetFocus(temperatureInputField)
+			return nil
+		}
+		if event.Rune() == 'l' {
+			if scratch {
+				flash("[yellow::]The scratch chat always starts over at %d tokens[-]", maxTokens)
+				return nil
+			}
+			c, ok := m[metadataTitle]
+			if !ok {
+				return nil
+			}
 
-						if list.GetItemCount() == 0 {
-							textView.Clear()
-							list.SetCurrentItem(-1)
-							app.SetFocus(textArea)
+			text := ""
+			if c.ContextLimit > 0 {
+				text = strconv.Itoa(c.ContextLimit)
+			}
+			limitInputField.SetText(text).
+				SetDoneFunc(func(key tcell.Key) {
+					switch key {
+					case tcell.KeyESC:
+						pages.HidePage(pageLimit)
+						app.SetFocus(metadataReturnFocus)
+					case tcell.KeyEnter:
+						changed := *c
+						changed.ContextLimit = 0
+						if text := strings.TrimSpace(limitInputField.GetText()); text != "" {
+							n, err := strconv.Atoi(text)
+							if err != nil || n <= 0 {
+								flash("[yellow::]Enter a number of tokens, or nothing for the default[-]")
+								return
+							}
+							changed.ContextLimit = n
+						}
+						pages.HidePage(pageLimit)
+						app.SetFocus(metadataReturnFocus)
+						if err := saveConversation(metadataTitle, &changed); err != nil {
+							flash("[red::]%s[-]", err)
+							return
+						}
+						if changed.ContextLimit == 0 {
+							flash("%q now starts over at the default of %d tokens", metadataTitle, maxTokens)
+						} else {
+							flash("%q now starts over at %d tokens", metadataTitle, changed.ContextLimit)
+						}
+					}
+				})
+			pages.HidePage(pageMetadata)
+			pages.ShowPage(pageLimit)
+			app.SetFocus(limitInputField)
+			return nil
+		}
+		return event
+	})
+	// showMetadata opens the metadata panel of the current conversation.
+	showMetadata := func() {
+		title, c := currentConversation()
+		if c == nil {
+			return
+		}
+
+		metadataReturnFocus = app.GetFocus()
+		metadataTitle = title
+		text := conversationMetadata(title, c)
+		metadataView.SetText(text)
+		pages.AddPage(pageMetadata, center(metadataView, 60, strings.Count(text, "\n")+3), true, true)
+		app.SetFocus(metadataView)
+	}
+
+	// exportConversation saves the current conversation as an HTML page to share.
+	exportConversation := func() {
+		title, c := currentConversation()
+		if c == nil {
+			flash("[yellow::]There is no conversation to export[-]")
+			return
+		}
+		path, err := exportHTML(exportDir, title, c)
+		if err != nil {
+			flash("[red::]%s[-]", err)
+			return
+		}
+		flash("Exported to %s", path)
+	}
+	// exporting is set while all conversations are exported, so that it is not started twice
+	var exporting bool
+	// exportAll exports every conversation in the background and counts them in the status bar as it goes.
+	exportAll := func() {
+		if exporting {
+			flash("[yellow::]The conversations are already being exported[-]")
+			return
+		}
+		if len(m) == 0 {
+			flash("[yellow::]There is no conversation to export[-]")
+			return
+		}
+
+		// export copies, the conversations may change while they are written
+		titles := make([]string, 0, len(m))
+		for title := range m {
+			titles = append(titles, title)
+		}
+		sortTitles(titles, m, sortBy)
+		convs := make([]*Conversation, len(titles))
+		for i, title := range titles {
+			c := *m[title]
+			c.Messages = append([]Message(nil), c.Messages...)
+			convs[i] = &c
+		}
+
+		exporting = true
+		go func() {
+			var (
+				failed   int
+				firstErr error
+			)
+			for i, title := range titles {
+				if _, err := exportHTML(exportDir, title, convs[i]); err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					failed++
+				}
+				done := i + 1
+				app.QueueUpdateDraw(func() {
+					statusBar.SetText(fmt.Sprintf("Exporting %d/%d conversations…", done, len(titles)))
+				})
+			}
+			app.QueueUpdateDraw(func() {
+				exporting = false
+				if failed > 0 {
+					flash("[red::]%d of %d conversations could not be exported: %s[-]", failed, len(titles), firstErr)
+				} else {
+					flash("Exported %d conversations to %s", len(titles), exportDir)
+				}
+			})
+		}()
+	}
+
+	statsModal := tview.NewModal().AddButtons([]string{buttonOK})
+	// showStats opens the word and token statistics of the current conversation.
+	showStats := func() {
+		title, c := currentConversation()
+		if c == nil {
+			return
+		}
+
+		returnFocus := app.GetFocus()
+		statsModal.SetText(conversationStats(title, c)).
+			SetFocus(0).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				pages.HidePage(pageStats)
+				app.SetFocus(returnFocus)
+			})
+		pages.AddPage(pageStats, statsModal, true, true)
+		app.SetFocus(statsModal)
+	}
+
+	var (
+		selectMode      bool
+		selectedMessage int
+	)
+	highlightMessage := func(i int) {
+		selectedMessage = i
+		textView.Highlight(messageRegion(i))
+		textView.ScrollToHighlight()
+	}
+	// writeReply writes s to textView unless it was switched away from the streaming reply.
+	writeReply := func(s string) {
+		if !detached {
+			fmt.Fprint(textView, s)
+		}
+	}
+
+	// setReplyText replaces the text of textView once a reply arrived and scrolls to its end,
+	// or keeps the view where it is if follow is off.
+	setReplyText := func(text string) {
+		row, col := textView.GetScrollOffset()
+		textView.SetText(text)
+		if follow && render.newestFirst {
+			textView.ScrollToBeginning()
+		} else if follow {
+			textView.ScrollToEnd()
+		} else {
+			textView.ScrollTo(row, col)
+		}
+	}
+
+	// countRequest adds a request of messages answered by reply to the usage of the session.
+	countRequest := func(messages []Message, reply string) {
+		tokens, _ := NumTokensFromMessages(append(append([]Message(nil), messages...), Message{Role: roleAssistant, Content: reply}), gpt3Dot5Turbo)
+		app.QueueUpdateDraw(func() {
+			usage.add(tokens)
+			updateStatus()
+		})
+	}
+	// capReached reports whether a session cap blocks further requests.
+	// It is for the requests sent without asking, and must not be called from the event loop.
+	capReached := func() bool {
+		capped := make(chan bool, 1)
+		app.QueueUpdateDraw(func() {
+			capped <- usage.capped()
+		})
+		return <-capped
+	}
+
+	// askTitle asks the model for the title of a conversation about content.
+	askTitle := func(content string) (string, error) {
+		if capReached() {
+			return "", errSessionCapped
+		}
+
+		respCh := make(chan *StreamingResponse)
+		errCh := make(chan error, 1)
+		messages := []Message{
+			{
+				Role:    roleUser,
+				Content: cfg.titlePrompt(content),
+			},
+		}
+		go streamChatCompletion(context.Background(), &Request{
+			Model:    gpt3Dot5Turbo,
+			Messages: messages,
+			Stream:   true,
+		}, respCh, errCh)
+
+		var sb strings.Builder
+		for chunk := range respCh {
+			for _, choice := range chunk.Choices {
+				sb.WriteString(choice.Delta.Content)
+			}
+		}
+		countRequest(messages, sb.String())
+		select {
+		case err := <-errCh:
+			return "", err
+		default:
+		}
+		// a title goes on one line of the history
+		first, _, _ := strings.Cut(strings.TrimSpace(sb.String()), "\n")
+		title := cfg.truncateTitle(strings.Trim(first, "\""))
+		if title == "" {
+			return "", errors.New("no title was suggested")
+		}
+		return title, nil
+	}
+	// readReply streams the reply of model to messages into textView.
+	// A nil temperature uses the one of the config.
+	readReply := func(ctx context.Context, model string, temperature *float64, messages []Message) (*streamedReply, error) {
+		respCh := make(chan *StreamingResponse)
+		errCh := make(chan error, 1)
+		go streamChatCompletion(ctx, cfg.newRequest(model, temperature, messages, true), respCh, errCh)
+
+		reply := new(streamedReply)
+		// the tokens of the reply are counted as it arrives to show how much of the max tokens it used
+		var (
+			encoding *tiktoken.Tiktoken
+			tokens   int
+			progress string
+		)
+		if cfg.MaxReplyTokens > 0 {
+			encoding, _ = tiktoken.EncodingForModel(gpt3Dot5Turbo)
+		}
+		// add merges chunk into the reply and returns what to show of it,
+		// the transcript gets the content as it arrives
+		add := func(chunk *StreamingResponse) string {
+			reasoning, content := reply.add(chunk)
+			if tr != nil {
+				fmt.Fprint(tr, content)
+			}
+			if encoding != nil {
+				tokens += len(encoding.Encode(content, nil, nil))
+			}
+			return reply.display(reasoning, content, render.reasoning)
+		}
+		// write shows s of the reply, and how much of the max tokens it used next to its label
+		write := func(s string) {
+			writeReply(s)
+			if encoding == nil || detached {
+				return
+			}
+			if p := replyProgress(tokens, cfg.MaxReplyTokens); p != progress {
+				progress = p
+				setReplyText(withProgress(textView.GetText(false), progress))
+			}
+		}
+
+		if cfg.TypingInterval > 0 {
+			ticker := time.NewTicker(time.Duration(cfg.TypingInterval) * time.Millisecond)
+			var pending strings.Builder
+		loop:
+			for {
+				select {
+				case chunk, ok := <-respCh:
+					if !ok {
+						write(pending.String())
+						break loop
+					}
+					pending.WriteString(add(chunk))
+				case <-ticker.C:
+					if pending.Len() > 0 {
+						write(pending.String())
+						pending.Reset()
+					}
+				}
+			}
+			ticker.Stop()
+		} else {
+			for chunk := range respCh {
+				write(add(chunk))
+			}
+		}
+		countRequest(messages, reply.Content)
+
+		select {
+		case err := <-errCh:
+			return reply, err
+		default:
+			return reply, nil
+		}
+	}
+
+	// fetchReply streams the reply of model to messages, retrying once if it is empty
+	// and resuming it if it was interrupted.
+	fetchReply := func(ctx context.Context, model string, temperature *float64, messages []Message) (*streamedReply, error) {
+		reply, err := readReply(ctx, model, temperature, messages)
+		// asking again would be blocked again
+		if err == nil && reply.Content == "" && reply.FinishReason != finishContentFilter {
+			reply, err = readReply(ctx, model, temperature, messages)
+		}
+		// pick up an interrupted reply where it stopped, unless it was stopped on purpose
+		for attempt := 0; cfg.ResumeStreams && err != nil && ctx.Err() == nil && reply.Content != "" && attempt < maxResumeAttempts; attempt++ {
+			resume := append(append([]Message(nil), messages...),
+				Message{
+					Role:    roleAssistant,
+					Content: reply.Content,
+				},
+				Message{
+					Role:    roleUser,
+					Content: promptContinue,
+				},
+			)
+
+			var rest *streamedReply
+			rest, err = readReply(ctx, model, temperature, resume)
+			reply.Content += rest.Content
+			reply.Reasoning += rest.Reasoning
+			reply.Logprobs = append(reply.Logprobs, rest.Logprobs...)
+			if rest.ID != "" {
+				reply.ID = rest.ID
+			}
+			reply.FinishReason = rest.FinishReason
+		}
+		if fingerprint := reply.SystemFingerprint; fingerprint != "" {
+			app.QueueUpdateDraw(func() {
+				systemFingerprint = fingerprint
+				updateStatus()
+			})
+		}
+		return reply, err
+	}
+
+	choiceList := tview.NewList().ShowSecondaryText(false)
+	choiceList.SetTitle("Replies").SetBorder(true)
+	choicePreview := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	choicePreview.SetTitle("enter: keep, esc: discard all").SetBorder(true)
+	choicesFlex := tview.NewFlex().
+		AddItem(choiceList, 14, 0, true).
+		AddItem(choicePreview, 0, 1, false)
+	// chooseReply shows contents side by side with a preview and waits until the user picks one.
+	// It reports false if they were all discarded. It must not be called from the event loop.
+	chooseReply := func(contents []string) (int, bool) {
+		chosen := make(chan int, 1)
+		app.QueueUpdateDraw(func() {
+			returnFocus := app.GetFocus()
+			done := func(i int) {
+				pages.HidePage(pageChoices)
+				app.SetFocus(returnFocus)
+				chosen <- i
+			}
+			preview := func(i int) {
+				content := contents[i]
+				if render.markdown {
+					content = formatMarkdown(content, render.lineNumbers)
+				}
+				choicePreview.SetText(content).ScrollToBeginning()
+			}
+
+			choiceList.Clear()
+			choiceList.SetChangedFunc(nil)
+			for i := range contents {
+				i := i
+				choiceList.AddItem(fmt.Sprintf("Reply %d", i+1), "", rune('1'+i), func() {
+					done(i)
+				})
+			}
+			choiceList.SetChangedFunc(func(index int, mainText string, secondaryText string, shortcut rune) {
+				preview(index)
+			})
+			choiceList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+				if event.Key() == tcell.KeyESC {
+					done(-1)
+					return nil
+				}
+				return event
+			})
+			preview(0)
+			pages.AddPage(pageChoices, center(choicesFlex, 100, 30), true, true)
+			app.SetFocus(choiceList)
+		})
+		i := <-chosen
+		return i, i >= 0
+	}
+
+	// requestReply streams the reply of model to messages, or asks for several replies at once
+	// and lets the user pick one if choices are configured.
+	requestReply := func(ctx context.Context, model string, temperature *float64, messages []Message) (*streamedReply, error) {
+		if cfg.Choices <= 1 {
+			return fetchReply(ctx, model, temperature, messages)
+		}
+
+		completion, err := completeChoices(ctx, cfg.newRequest(model, temperature, messages, false))
+		if err != nil {
+			countRequest(messages, "")
+			return new(streamedReply), err
+		}
+		app.QueueUpdateDraw(func() {
+			usage.add(completion.Usage.TotalTokens)
+			updateStatus()
+		})
+
+		contents := make([]string, len(completion.Choices))
+		for i, choice := range completion.Choices {
+			contents[i] = choice.Message.Content
+		}
+		i := 0
+		if len(contents) > 1 {
+			var ok bool
+			if i, ok = chooseReply(contents); !ok {
+				return new(streamedReply), errNoReplyChosen
+			}
+		}
+
+		choice := completion.Choices[i]
+		reply := &streamedReply{ID: completion.Id, Content: choice.Message.Content, FinishReason: choice.FinishReason}
+		if choice.Logprobs != nil {
+			reply.Logprobs = choice.Logprobs.Content
+		}
+		writeReply(reply.Content)
+		if tr != nil {
+			fmt.Fprint(tr, reply.Content)
+		}
+		return reply, nil
+	}
+
+	// replyWithFallback requests the reply of model to messages. As long as requests fail
+	// before anything was received, it falls back to the next of the fallback models.
+	// The request can be stopped with the stop button while it is in flight.
+	// It must not be called from the event loop.
+	replyWithFallback := func(model string, temperature *float64, messages []Message) (*streamedReply, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		app.QueueUpdateDraw(func() {
+			cancelReply = cancel
+			showStop(true)
+		})
+		defer app.QueueUpdateDraw(func() {
+			cancelReply = nil
+			showStop(false)
+		})
+
+		reply, err := requestReply(ctx, model, temperature, messages)
+		tried := map[string]bool{model: true}
+		for _, fallback := range cfg.FallbackModels {
+			if err == nil || reply.Content != "" || errors.Is(err, errNoReplyChosen) || errors.Is(err, context.Canceled) {
+				break
+			}
+			if tried[fallback] {
+				continue
+			}
+			tried[fallback] = true
+			failed, next := model, fallback
+			app.QueueUpdateDraw(func() {
+				flash("[yellow::]%s failed, falling back to %s[-]", failed, next)
+			})
+			model = fallback
+			reply, err = requestReply(ctx, model, temperature, messages)
+		}
+		reply.Model = model
+		return reply, err
+	}
+
+	capModal := tview.NewModal().AddButtons([]string{buttonCancel, buttonGoOver})
+	// checkCap reports whether a request may be sent. Once a session cap is reached,
+	// it asks whether to go over it for the rest of the session instead.
+	checkCap := func() bool {
+		if !usage.capped() {
+			return true
+		}
+
+		returnFocus := app.GetFocus()
+		capModal.SetText(fmt.Sprintf("This session reached its cap (%s). Keep sending requests anyway?", tview.Escape(usage.String()))).
+			SetFocus(0).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				pages.HidePage(pageCap)
+				app.SetFocus(returnFocus)
+				if buttonLabel == buttonGoOver {
+					usage.override = true
+					flash("Cap lifted for this session, submit again")
+				}
+			})
+		pages.AddPage(pageCap, capModal, true, true)
+		app.SetFocus(capModal)
+		return false
+	}
+
+	// isShown reports whether the conversation with the given title, or the scratch chat, is in textView.
+	isShown := func(title string, isScratch bool) bool {
+		if isScratch || scratch {
+			return isScratch && scratch
+		}
+		current, _ := list.GetItemText(list.GetCurrentItem())
+		return !isNewChat && current == title
+	}
+	// finishReply marks c as viewed if its new reply is shown, or as unread otherwise.
+	finishReply := func(title string, c *Conversation) {
+		switch {
+		case isShown(title, false):
+			c.Viewed = c.Time
+		case c.Viewed == 0 || c.Viewed >= c.Time:
+			// the reply may land within the second the conversation was last shown
+			c.Viewed = c.Time - 1
+		}
+	}
+
+	// queued is the question sent once the streaming reply finished, "" while there is none
+	var queued string
+	// sendQueued sends a question which was queued, it is set once questions can be sent
+	var sendQueued func(content string)
+	// editTitle asks for a new title of the conversation at index in the history, starting from text
+	// or from its current title, and renames it. It is set along with the keys of the history.
+	var editTitle func(currentIndex int, text string, returnFocus tview.Primitive)
+	// endStream lets questions be sent again once a reply finished streaming, and sends the queued one.
+	// It runs on the event loop.
+	endStream := func() {
+		textArea.SetDisabled(false)
+		streaming = false
+		if content := queued; content != "" && sendQueued != nil {
+			queued = ""
+			sendQueued(content)
+		}
+	}
+
+	// regenerate replaces the last reply of the current conversation by a reply of model.
+	// The model of the conversation stays the same.
+	regenerate := func(model string) {
+		title, c := currentConversation()
+		if c == nil || len(c.Messages) == 0 || c.Messages[len(c.Messages)-1].Role != roleAssistant {
+			flash("[yellow::]There is no reply to regenerate[-]")
+			return
+		}
+
+		isScratch := scratch
+		messages := append([]Message(nil), c.Messages[:len(c.Messages)-1]...)
+		streaming = true
+		detached = false
+		textArea.SetDisabled(!cfg.QueueQuestions)
+		setReplyText(renderConversation(&Conversation{Model: c.Model, Messages: messages, ContextReset: c.ContextReset}, render))
+		if textView.GetText(false) != "" {
+			fmt.Fprint(textView, messageSeparator(render.spacing))
+		}
+		receivedAt := time.Now().Unix()
+		// note the model on the reply unless it is the conversation's
+		noted := model
+		if model == conversationModel(c) {
+			noted = ""
+		}
+		header := render
+		header.model = model
+		fmt.Fprintf(textView, `["%s"]%s`+"\n", messageRegion(len(messages)), messageHeader(Message{Role: roleAssistant, Time: receivedAt, Model: noted}, header))
+		if tr != nil {
+			tr.header(title, roleAssistant, receivedAt)
+		}
+
+		go func() {
+			reply, err := replyWithFallback(model, c.Temperature, trimContext(messages, cfg.ContextWindow))
+			if tr != nil {
+				tr.end(err)
+			}
+			app.QueueUpdateDraw(func() {
+				// a stopped reply does not replace the previous one
+				if err != nil || reply.Content == "" {
+					switch {
+					case errors.Is(err, context.Canceled):
+						writeReply("[yellow::][stopped[][-]")
+					case err != nil:
+						writeReply(fmt.Sprintf("[red::]%s[-]", tview.Escape(err.Error())))
+					case reply.FinishReason == finishContentFilter:
+						writeReply(contentFilterNotice)
+					default:
+						writeReply("[red::][empty response, try again[][-]")
+					}
+					writeReply(`[""]` + "\n[yellow::][the previous reply is kept[][-]")
+					endStream()
+					return
+				}
+
+				// a fallback model may have replied instead
+				noted = reply.Model
+				if noted == conversationModel(c) {
+					noted = ""
+				}
+				messages = append(messages, Message{
+					Role:         roleAssistant,
+					Content:      reply.Content,
+					Time:         receivedAt,
+					Reasoning:    reply.Reasoning,
+					Model:        noted,
+					Logprobs:     reply.Logprobs,
+					FinishReason: reply.FinishReason,
+				})
+				regenerated := *c
+				regenerated.Time = time.Now().Unix()
+				regenerated.Messages = messages
+				regenerated.ResponseID = reply.ID
+				if isScratch {
+					scratchMessages = messages
+					scratchResponse = reply.ID
+				} else {
+					finishReply(title, &regenerated)
+					if err := saveConversation(title, &regenerated); err != nil {
+						log.Panic(err)
+					}
+					refreshItem(title)
+				}
+
+				if isShown(title, isScratch) {
+					setReplyText(renderConversation(&regenerated, render))
+				}
+				endStream()
+			})
+		}()
+	}
+
+	// rerender redraws the current conversation after a change of the render options, keeping the scroll position.
+	rerender := func() {
+		title, c := currentConversation()
+		if c == nil {
+			return
+		}
+		row, col := textView.GetScrollOffset()
+		if scratch {
+			textView.SetText(renderConversation(c, render))
+		} else {
+			showConversation(title)
+		}
+		textView.ScrollTo(row, col)
+	}
+
+	textView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if selectMode {
+			_, c := currentConversation()
+			if c == nil || len(c.Messages) == 0 {
+				selectMode = false
+				textView.Highlight()
+				return event
+			}
+			// the compact view has no regions for the messages before the latest exchange
+			first := 0
+			if render.compact {
+				first = latestExchange(c.Messages)
+			}
+
+			switch boundKey(contextSelection, event) {
+			case "esc":
+				selectMode = false
+				textView.Highlight()
+			case "enter":
+				if err := writeClipboard(c.Messages[selectedMessage].Content); err != nil {
+					flash("[red::]%s[-]", err)
+				} else {
+					flash("Copied message to clipboard")
+				}
+			case "k", "up":
+				if selectedMessage > first {
+					highlightMessage(selectedMessage - 1)
+				}
+			case "j", "down":
+				if selectedMessage < len(c.Messages)-1 {
+					highlightMessage(selectedMessage + 1)
+				}
+			case "o":
+				open := func(link string) {
+					if err := openLink(link); err != nil {
+						flash("[red::]%s[-]", err)
+					} else {
+						flash("Opened %s", link)
+					}
+				}
+				switch links := findLinks(c.Messages[selectedMessage].Content); len(links) {
+				case 0:
+					flash("[yellow::]No links in the message[-]")
+				case 1:
+					open(links[0])
+				default:
+					pick("Open link", links, textView, open)
+				}
+			case "p":
+				if streaming {
+					flash("[yellow::]Wait for the reply to finish[-]")
+					break
+				}
+				msg := &c.Messages[selectedMessage]
+				msg.Pinned = !msg.Pinned
+				if !scratch {
+					title, _ := list.GetItemText(list.GetCurrentItem())
+					if err := saveConversation(title, c); err != nil {
+						flash("[red::]%s[-]", err)
+						break
+					}
+				}
+				rerender()
+				highlightMessage(selectedMessage)
+				if msg.Pinned {
+					flash("Pinned, the message is always sent")
+				} else {
+					flash("Unpinned")
+				}
+			case "z":
+				msg := c.Messages[selectedMessage]
+				if msg.Role != roleAssistant || !strings.Contains(strings.TrimRight(msg.Content, "\n"), "\n") {
+					flash("[yellow::]Select a reply of more than one line to fold[-]")
+					break
+				}
+				if c.Folded == nil {
+					c.Folded = make(map[int]bool)
+					if scratch {
+						scratchFolded = c.Folded
+					}
+				}
+				c.Folded[selectedMessage] = !c.Folded[selectedMessage]
+				rerender()
+				highlightMessage(selectedMessage)
+			case "b":
+				if scratch {
+					flash("[yellow::]Cannot branch from the scratch chat[-]")
+					break
+				}
+				if c.Messages[selectedMessage].Role != roleAssistant {
+					flash("[yellow::]Select a reply to branch from[-]")
+					break
+				}
+
+				title, _ := list.GetItemText(list.GetCurrentItem())
+				branchTitle := addSuffixNumber(title)
+				for m[branchTitle] != nil {
+					branchTitle = addSuffixNumber(branchTitle)
+				}
+
+				branch := &Conversation{
+					Time:         time.Now().Unix(),
+					Model:        c.Model,
+					Messages:     append([]Message(nil), c.Messages[:selectedMessage+1]...),
+					ContextReset: c.ContextReset,
+				}
+				if err := saveConversation(branchTitle, branch); err != nil {
+					flash("[red::]%s[-]", err)
+					break
+				}
+
+				selectMode = false
+				textView.Highlight()
+				list.InsertItem(0, branchTitle, historyItemText(branch), rune(0), nil)
+				list.SetCurrentItem(0)
+				showConversation(branchTitle)
+				app.SetFocus(textArea)
+				flash("Branched into %q", branchTitle)
+			}
+			return nil
+		}
+
+		switch boundKey(contextConversation, event) {
+		case "esc":
+			escape(paneConversation)
+		case "enter":
+			app.SetFocus(textArea)
+		case "v":
+			if _, c := currentConversation(); c != nil && len(c.Messages) > 0 {
+				selectMode = true
+				highlightMessage(len(c.Messages) - 1)
+				return nil
+			}
+		case "u":
+			_, c := currentConversation()
+			if c == nil {
+				return nil
+			}
+			i := firstUnanswered(c.Messages)
+			if i < 0 {
+				flash("Every question was answered")
+				return nil
+			}
+			if render.compact && i < latestExchange(c.Messages) {
+				// the compact view has no region for the question
+				render.compact = false
+				rerender()
+			}
+			selectMode = true
+			highlightMessage(i)
+			flash("The question got no reply, enter copies it")
+			return nil
+		case "i":
+			showMetadata()
+			return nil
+		case "w":
+			showStats()
+			return nil
+		case "x":
+			exportConversation()
+			return nil
+		case "m":
+			render.markdown = !render.markdown
+			rerender()
+			if render.markdown {
+				flash("Markdown rendering on")
+			} else {
+				flash("Markdown rendering off")
+			}
+			return nil
+		case "s":
+			render.system = !render.system
+			rerender()
+			if render.system {
+				flash("System message shown")
+			} else {
+				flash("System message hidden")
+			}
+			return nil
+		case "g":
+			if streaming {
+				flash("[yellow::]Wait for the reply to finish[-]")
+				return nil
+			}
+			if checkCap() {
+				pickModel("Regenerate with", textView, regenerate)
+			}
+			return nil
+		case "t":
+			title, c := currentConversation()
+			if c == nil || scratch {
+				flash("[yellow::]Only a saved conversation can be renamed[-]")
+				return nil
+			}
+			if !checkCap() {
+				return nil
+			}
+			flash("Suggesting a title from the conversation…")
+			go func() {
+				suggested, err := askTitle(titleExcerpt(c.Messages))
+				app.QueueUpdateDraw(func() {
+					if err != nil {
+						flash("[red::]%s[-]", err)
+						return
+					}
+					// another conversation may have been opened meanwhile
+					if i := findItem(title); i >= 0 {
+						editTitle(i, suggested, app.GetFocus())
+					}
+				})
+			}()
+			return nil
+		case "c":
+			render.compact = !render.compact
+			rerender()
+			if render.compact {
+				textView.ScrollToBeginning()
+				flash("Showing the latest exchange")
+			} else {
+				flash("Showing the whole conversation")
+			}
+			return nil
+		case "J":
+			_, c := currentConversation()
+			if c == nil {
+				flash("[yellow::]There is no conversation to copy[-]")
+				return nil
+			}
+			// the messages as they are stored, to be shared
+			data, err := json.MarshalIndent(c.Messages, "", "  ")
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				return nil
+			}
+			if err := writeClipboard(string(data)); err != nil {
+				flash("[red::]%s[-]", err)
+			} else {
+				flash("Copied %d messages as JSON to clipboard", len(c.Messages))
+			}
+			return nil
+		case "P":
+			if streaming {
+				flash("[yellow::]Wait for the reply to finish[-]")
+				return nil
+			}
+			if _, c := currentConversation(); c == nil {
+				flash("[yellow::]Ask something first, the persona changes from there on[-]")
+				return nil
+			}
+			pick("Switch persona", personaNames(cfg.Personas), textView, func(name string) {
+				title, c := currentConversation()
+				if c == nil {
+					return
+				}
+				switched := personaMessage(cfg.Personas, name)
+				if scratch {
+					scratchMessages = append(scratchMessages, switched)
+				} else {
+					changed := *c
+					changed.Messages = append(append([]Message(nil), c.Messages...), switched)
+					if err := saveConversation(title, &changed); err != nil {
+						flash("[red::]%s[-]", err)
+						return
+					}
+				}
+				rerender()
+				flash("Switched to the persona %q", name)
+			})
+			return nil
+		case "n":
+			render.newestFirst = !render.newestFirst
+			rerender()
+			textView.ScrollToBeginning()
+			if render.newestFirst {
+				flash("Showing the newest exchange first")
+			} else {
+				flash("Showing the oldest exchange first")
+			}
+			return nil
+		case "l":
+			render.lineNumbers = !render.lineNumbers
+			cfg.LineNumbers = render.lineNumbers
+			rerender()
+			if err := saveConfigValue(configFile, "line_numbers", cfg.LineNumbers); err != nil {
+				flash("[red::]Cannot save the line numbers setting: %s[-]", err)
+			} else if render.lineNumbers {
+				flash("Code blocks numbered")
+			} else {
+				flash("Code blocks not numbered")
+			}
+			return nil
+		case "R":
+			render.rtl = !render.rtl
+			rerender()
+			if render.rtl {
+				flash("Right-to-left replies reversed")
+			} else {
+				flash("Right-to-left replies left as they are")
+			}
+			return nil
+		case "r":
+			render.reasoning = !render.reasoning
+			rerender()
+			if render.reasoning {
+				flash("Reasoning shown")
+			} else {
+				flash("Reasoning hidden")
+			}
+			return nil
+		case "f":
+			follow = !follow
+			if follow {
+				textView.ScrollToEnd()
+			} else {
+				// scrolling to the current position stops the view from tracking the end
+				row, col := textView.GetScrollOffset()
+				textView.ScrollTo(row, col)
+			}
+			updateStatus()
+			return nil
+		}
+		return event
+	})
+
+	// loadHistory fills m and the history list from the database.
+	loadHistory := func() {
+		for title := range m {
+			delete(m, title)
+		}
+
+		titles := make([]string, 0)
+		db.View(func(tx *buntdb.Tx) error {
+			err := tx.Descend("time", func(key, value string) bool {
+				if isMetaKey(key) {
+					return true
+				}
+
+				if c, err := dbCodec.decode(key, value); err == nil {
+					m[key] = c
+					titles = append(titles, key)
+				}
+				return true
+			})
+			return err
+		})
+		if cfg.ArchiveDays > 0 {
+			for _, title := range staleConversations(m, cfg.ArchiveDays, time.Now()) {
+				archived := *m[title]
+				archived.Archived = true
+				if err := saveConversation(title, &archived); err != nil {
+					log.Panic(err)
+				}
+			}
+		}
+		setListItems(listed(titles))
+	}
+
+	list.SetSelectedFocusOnly(true)
+	loadHistory()
+
+	// templateSeed are the example turns of the template the new chat was started from
+	var templateSeed []Message
+	startNewChat := func() {
+		if streaming {
+			detached = true
+		}
+		setScratch(false)
+		isNewChat = true
+		templateSeed = nil
+		list.SetSelectedFocusOnly(true)
+		textView.Clear()
+		app.SetFocus(textArea)
+	}
+
+	// reopenHistory opens the closed database file and keeps the current conversation selected if it still exists.
+	reopenHistory := func() {
+		current, _ := list.GetItemText(list.GetCurrentItem())
+		db, dbCodec, err = openDB(dbFile, cfg.Encrypt, askPassphrase)
+		if err != nil {
+			log.Panic(err)
+		}
+		loadHistory()
+
+		if i := findItem(current); i >= 0 {
+			list.SetCurrentItem(i)
+			if !isNewChat {
+				showConversation(current)
+			}
+		} else if !isNewChat {
+			textView.Clear()
+		}
+	}
+	// reloadHistory reads the database file again, after another program changed it for example.
+	reloadHistory := func() {
+		db.Close()
+		reopenHistory()
+	}
+
+	// compactHistory rewrites the database file without the space left by changed and deleted values.
+	// Replies are saved off the event loop, so it waits until none is streaming.
+	compactHistory := func() {
+		if streaming {
+			flash("[yellow::]Wait for the reply to finish[-]")
+			return
+		}
+		before, after, err := compactDB(db, dbFile)
+		if err != nil {
+			flash("[red::]Compacting the history failed: %s[-]", err)
+			return
+		}
+		flash("Compacted the history from %s to %s", formatSize(before), formatSize(after))
+	}
+
+	helpView := tview.NewTextView().SetDynamicColors(true).SetText(fullHelp())
+	helpView.SetTitle("Keybindings (esc to close)").SetBorder(true)
+	var helpReturnFocus tview.Primitive
+	helpView.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC || event.Rune() == '?' {
+			pages.HidePage(pageHelp)
+			app.SetFocus(helpReturnFocus)
+			return nil
+		}
+		return event
+	})
+
+	list.SetChangedFunc(func(index int, title string, secondaryText string, shortcut rune) {
+		if !populating {
+			showConversation(title)
+		}
+	})
+	list.SetSelectedFunc(func(index int, title string, secondaryText string, shortcut rune) {
+		list.SetSelectedFocusOnly(false)
+		showConversation(title)
+		app.SetFocus(textArea)
+	})
+
+	titleFieldWidth := 40
+	editTitleInputField := tview.NewInputField()
+	if cfg.MaxTitleLength > 0 {
+		titleFieldWidth = cfg.MaxTitleLength
+		editTitleInputField.SetAcceptanceFunc(tview.InputFieldMaxLength(cfg.MaxTitleLength))
+	}
+	editTitleInputField.SetFieldWidth(titleFieldWidth)
+	editTitleInputField.SetTitle("Edit title").SetBorder(true)
+
+	overwriteTitleModal := tview.NewModal().AddButtons([]string{buttonCancel, buttonOverwrite})
+
+	deleteTitleModal := tview.NewModal()
+	deleteTitleModal.AddButtons([]string{buttonCancel, buttonDelete})
+
+	deleteAllModal := tview.NewModal()
+	deleteAllModal.AddButtons([]string{buttonCancel, buttonDelete})
+	confirmDeleteAllInputField := tview.NewInputField().
+		SetLabel("Type DELETE to confirm: ").
+		SetFieldWidth(10)
+	confirmDeleteAllInputField.SetTitle("Delete all history").SetBorder(true)
+
+	backupList := tview.NewList().ShowSecondaryText(false)
+	backupList.SetTitle("Backups (enter to restore, esc to close)").SetBorder(true)
+	backupList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyESC {
+			pages.HidePage(pageBackups)
+			app.SetFocus(list)
+			return nil
+		}
+		return event
+	})
+	noteTextArea := tview.NewTextArea()
+	noteTextArea.SetTitle("Note (esc to save)").SetBorder(true)
+
+	restoreModal := tview.NewModal()
+	restoreModal.AddButtons([]string{buttonCancel, buttonRestore})
+
+	// lastQuery is the last non-empty search, which ctrl-g repeats
+	var lastQuery string
+	// search fills the history list with the conversations matching text, or all of them if it is empty.
+	search := func(text string) {
+		titles := make([]string, 0, len(m))
+		db.View(func(tx *buntdb.Tx) error {
+			err := tx.Descend("time", func(key, value string) bool {
+				if isMetaKey(key) {
+					return true
+				}
+				titles = append(titles, key)
+				return true
+			})
+			return err
+		})
+
+		if text != "" {
+			// notes are searched along with the titles
+			docs := make([]string, len(titles))
+			for i, title := range titles {
+				docs[i] = title
+				if c, ok := m[title]; ok && c.Note != "" {
+					docs[i] += "\n" + c.Note
+				}
+			}
+			idx := make(index)
+			idx.add(docs)
+			r := idx.search(text)
+			found := make([]string, 0, len(r))
+			for _, i := range r {
+				found = append(found, titles[i])
+			}
+			setListItems(found)
+		} else {
+			setListItems(listed(titles))
+		}
+		if list.GetItemCount() > 0 {
+			title, _ := list.GetItemText(0)
+			showConversation(title)
+			app.SetFocus(list)
+		}
+	}
+	searchInputField.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyESC:
+			escape(paneSearch)
+		case tcell.KeyEnter:
+			text := searchInputField.GetText()
+			if text != "" {
+				lastQuery = text
+			}
+			search(text)
+		}
+	})
+
+	// renameConversation stores the conversation from under the title to, in place of any conversation
+	// with that title, and renames its history item.
+	renameConversation := func(from, to string) error {
+		value, err := dbCodec.encode(to, m[from])
+		if err != nil {
+			return err
+		}
+		err = db.Update(func(tx *buntdb.Tx) error {
+			if _, _, err := tx.Set(to, value, nil); err != nil {
+				return err
+			}
+			_, err := tx.Delete(from)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		m[to] = m[from]
+		delete(m, from)
+
+		populating = true
+		defer func() {
+			populating = false
+		}()
+		current, _ := list.GetItemText(list.GetCurrentItem())
+		// the overwritten conversation leaves the list
+		if i := findItem(to); i >= 0 {
+			list.RemoveItem(i)
+		}
+		if i := findItem(from); i >= 0 {
+			list.SetItemText(i, to, historyItemText(m[to]))
+		}
+		if current == from || current == to {
+			list.SetCurrentItem(findItem(to))
+		}
+		return nil
+	}
+
+	editTitle = func(currentIndex int, text string, returnFocus tview.Primitive) {
+		currentTitle, _ := list.GetItemText(currentIndex)
+		if text == "" {
+			text = currentTitle
+		}
+		editTitleInputField.
+			SetText(text).
+			SetDoneFunc(func(key tcell.Key) {
+				switch key {
+				case tcell.KeyESC:
+					pages.HidePage(pageEditTitle)
+					app.SetFocus(returnFocus)
+				case tcell.KeyEnter:
+					newTitle := editTitleInputField.GetText()
+					rename := func() {
+						if err := renameConversation(currentTitle, newTitle); err != nil {
+							flash("[red::]%s[-]", err)
 						}
+					}
+
+					pages.HidePage(pageEditTitle)
+					app.SetFocus(returnFocus)
+					if newTitle == currentTitle {
+						break
+					}
+					if _, taken := m[newTitle]; !taken {
+						rename()
+						break
+					}
+
+					overwriteTitleModal.SetText(fmt.Sprintf("\"%s\" already exists. Overwrite it with \"%s\"?", tview.Escape(newTitle), tview.Escape(currentTitle))).
+						SetFocus(0).
+						SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+							pages.HidePage(pageOverwriteTitle)
+							app.SetFocus(returnFocus)
+							if buttonLabel == buttonOverwrite {
+								rename()
+							}
+						})
+					pages.ShowPage(pageOverwriteTitle)
+					app.SetFocus(overwriteTitleModal)
+				}
+			})
+		pages.ShowPage(pageEditTitle)
+	}
+
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch boundKey(contextHistory, event) {
+		case "esc":
+			escape(paneHistory)
+		case "j":
+			if list.GetCurrentItem() < list.GetItemCount() {
+				list.SetCurrentItem(list.GetCurrentItem() + 1)
+			}
+		case "k":
+			if list.GetCurrentItem() > 0 {
+				list.SetCurrentItem(list.GetCurrentItem() - 1)
+			}
+		case "e":
+			editTitle(list.GetCurrentItem(), "", list)
+		case "d":
+			currentIndex := list.GetCurrentItem()
+			currentTitle, _ := list.GetItemText(currentIndex)
+
+			question := fmt.Sprintf("Are you sure you want to delete \"%s\"?", currentTitle)
+			if cfg.TrashDays > 0 {
+				question = fmt.Sprintf("Move \"%s\" to the trash? It can be restored for %d days.", currentTitle, cfg.TrashDays)
+			}
+			deleteTitleModal.SetText(question).
+				SetFocus(0).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					switch buttonLabel {
+					case buttonCancel:
+						pages.HidePage(pageDeleteTitle)
+						app.SetFocus(list)
 
-						db.Update(func(tx *buntdb.Tx) error {
+					case buttonDelete:
+						err := db.Update(func(tx *buntdb.Tx) error {
+							if cfg.TrashDays > 0 {
+								return moveToTrash(tx, currentTitle, time.Now())
+							}
 							_, err := tx.Delete(currentTitle)
 							return err
 						})
+						pages.HidePage(pageDeleteTitle)
+						if err != nil {
+							flash("[red::]%s[-]", err)
+							app.SetFocus(list)
+							return
+						}
 						delete(m, currentTitle)
 
-						pages.HidePage(pageDeleteTitle)
+						list.RemoveItem(currentIndex)
+						if list.GetItemCount() == 0 {
+							textView.Clear()
+							list.SetCurrentItem(-1)
+						}
 						if list.GetItemCount() > 0 {
 							app.SetFocus(list)
 						} else {
@@ -330,160 +2151,613 @@ func main() {
 					return event
 				})
 			pages.ShowPage(pageDeleteTitle)
+		case "t":
+			showTrash()
+			return nil
+		case "a":
+			currentIndex := list.GetCurrentItem()
+			currentTitle, _ := list.GetItemText(currentIndex)
+			c, ok := m[currentTitle]
+			if !ok {
+				return nil
+			}
+			changed := *c
+			changed.Archived = !c.Archived
+			if !changed.Archived {
+				// a restored conversation counts as touched, or it would be archived again at the next start
+				changed.Time = time.Now().Unix()
+			}
+			if err := saveConversation(currentTitle, &changed); err != nil {
+				flash("[red::]%s[-]", err)
+				return nil
+			}
+			if changed.Archived && !showArchived {
+				list.RemoveItem(currentIndex)
+				if list.GetItemCount() == 0 {
+					textView.Clear()
+					list.SetCurrentItem(-1)
+					app.SetFocus(textArea)
+				}
+				flash("Archived %q, A shows archived conversations", currentTitle)
+				return nil
+			}
+			refreshItem(currentTitle)
+			if changed.Archived {
+				flash("Archived %q", currentTitle)
+			} else {
+				flash("Restored %q from the archive", currentTitle)
+			}
+			return nil
+		case "A":
+			currentTitle, _ := list.GetItemText(list.GetCurrentItem())
+			showArchived = !showArchived
+			titles := make([]string, 0, len(m))
+			for title := range m {
+				titles = append(titles, title)
+			}
+			setListItems(listed(titles))
+			if i := findItem(currentTitle); i >= 0 {
+				list.SetCurrentItem(i)
+			}
+			if showArchived {
+				flash("Showing archived conversations")
+			} else {
+				flash("Archived conversations hidden")
+			}
+			return nil
+		case "D":
+			if streaming {
+				flash("[yellow::]Wait for the reply to finish[-]")
+				return nil
+			}
+
+			deleteAllModal.SetText(fmt.Sprintf("Are you sure you want to delete all %d conversations and empty the trash? This cannot be undone.", len(m))).
+				SetFocus(0).
+				SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+					pages.HidePage(pageDeleteAll)
+					switch buttonLabel {
+					case buttonDelete:
+						confirmDeleteAllInputField.SetText("")
+						pages.ShowPage(pageConfirmAll)
+						app.SetFocus(confirmDeleteAllInputField)
+					default:
+						app.SetFocus(list)
+					}
+				})
+			confirmDeleteAllInputField.SetDoneFunc(func(key tcell.Key) {
+				switch key {
+				case tcell.KeyESC:
+					pages.HidePage(pageConfirmAll)
+					app.SetFocus(list)
+				case tcell.KeyEnter:
+					if confirmDeleteAllInputField.GetText() != "DELETE" {
+						flash("[yellow::]Type DELETE to confirm or esc to cancel[-]")
+						return
+					}
+
+					err := db.Update(func(tx *buntdb.Tx) error {
+						for title := range m {
+							if _, err := tx.Delete(title); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+								return err
+							}
+						}
+						return emptyTrash(tx)
+					})
+					pages.HidePage(pageConfirmAll)
+					if err != nil {
+						flash("[red::]%s[-]", err)
+						app.SetFocus(list)
+						return
+					}
+
+					n := len(m)
+					for title := range m {
+						delete(m, title)
+					}
+					list.Clear()
+					startNewChat()
+					flash("Deleted %d conversations", n)
+				}
+			})
+			pages.ShowPage(pageDeleteAll)
+			return nil
+		case "b":
+			if streaming {
+				flash("[yellow::]Wait for the reply to finish[-]")
+				return nil
+			}
+
+			backups, err := listBackups(backupDir)
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				return nil
+			}
+			if len(backups) == 0 {
+				flash("No backups in %s", backupDir)
+				return nil
+			}
+
+			backupList.Clear()
+			for _, name := range backups {
+				name := name
+				taken, _ := backupTime(name)
+				backupList.AddItem(taken.Format(time.DateTime), "", rune(0), func() {
+					restoreModal.SetText(fmt.Sprintf("Restore the history from %s? The current history is backed up first.", taken.Format(time.DateTime))).
+						SetFocus(0).
+						SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+							pages.HidePage(pageRestore)
+							if buttonLabel != buttonRestore {
+								app.SetFocus(backupList)
+								return
+							}
+
+							if _, err := writeBackup(db, backupDir, 0); err != nil {
+								flash("[red::]Cannot back up the current history: %s[-]", err)
+								app.SetFocus(backupList)
+								return
+							}
+							// the open database would write over the restored file
+							db.Close()
+							err := restoreBackup(filepath.Join(backupDir, name), dbFile)
+							// the current file is opened again if it was kept
+							reopenHistory()
+							if err != nil {
+								flash("[red::]%s[-]", err)
+								app.SetFocus(backupList)
+								return
+							}
+							pages.HidePage(pageBackups)
+							app.SetFocus(list)
+							flash("Restored %d conversations", len(m))
+						})
+					pages.ShowPage(pageRestore)
+					app.SetFocus(restoreModal)
+				})
+			}
+			pages.ShowPage(pageBackups)
+			app.SetFocus(backupList)
+			return nil
+		case "c":
+			compactHistory()
+			return nil
+		case "i":
+			showMetadata()
+			return nil
+		case "w":
+			showStats()
+			return nil
+		case "x":
+			exportConversation()
+			return nil
+		case "X":
+			exportAll()
+			return nil
+		case "f":
+			if err := revealDir(dbPath); err != nil {
+				flash("[red::]%s[-]", err)
+			} else {
+				flash("Opened %s", dbPath)
+			}
+			return nil
+		case "n":
+			title, _ := list.GetItemText(list.GetCurrentItem())
+			c, ok := m[title]
+			if !ok {
+				return nil
+			}
+
+			noteTextArea.SetText(c.Note, true)
+			noteTextArea.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+				if event.Key() != tcell.KeyESC {
+					return event
+				}
+
+				pages.HidePage(pageNote)
+				app.SetFocus(list)
+				note := strings.TrimSpace(noteTextArea.GetText())
+				if note == c.Note {
+					return nil
+				}
+				annotated := *c
+				annotated.Note = note
+				if err := saveConversation(title, &annotated); err != nil {
+					flash("[red::]%s[-]", err)
+				} else {
+					flash("Saved the note of %q", title)
+				}
+				return nil
+			})
+			pages.ShowPage(pageNote)
+			app.SetFocus(noteTextArea)
+			return nil
+		case "s":
+			currentTitle, _ := list.GetItemText(list.GetCurrentItem())
+			titles := make([]string, 0, list.GetItemCount())
+			for i := 0; i < list.GetItemCount(); i++ {
+				title, _ := list.GetItemText(i)
+				titles = append(titles, title)
+			}
+
+			sortBy = sortBy.next()
+			setListItems(titles)
+			if i := findItem(currentTitle); i >= 0 {
+				list.SetCurrentItem(i)
+			}
+			updateStatus()
+			return nil
 		}
 
 		return event
 	})
 
-	textArea.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Key() {
-		case tcell.KeyESC:
-			if textView.GetText(false) != "" || !isNewChat {
-				app.SetFocus(textView)
+	// suggestTitle asks the model for the title of a chat about content and sends it to titleCh.
+	// The title is shown in the history as it streams in.
+	suggestTitle := func(content string, titleCh chan<- string) {
+		if capReached() {
+			// name the chat after the start of content instead
+			first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+			titleCh <- cfg.truncateTitle(first)
+			return
+		}
+
+		respCh := make(chan *StreamingResponse)
+		errCh := make(chan error, 1)
+		messages := []Message{
+			{
+				Role:    roleUser,
+				Content: cfg.titlePrompt(content),
+			},
+		}
+		go streamChatCompletion(context.Background(), &Request{
+			Model:    gpt3Dot5Turbo,
+			Messages: messages,
+			Stream:   true,
+		}, respCh, errCh)
+
+		var (
+			sb    strings.Builder
+			shown string
+		)
+		for chunk := range respCh {
+			for _, choice := range chunk.Choices {
+				sb.WriteString(choice.Delta.Content)
 			}
-		case tcell.KeyEnter:
-			content := textArea.GetText()
-			if strings.TrimSpace(content) == "" {
-				return nil
+			// the title stops changing once it is longer than can be shown
+			title := cfg.truncateTitle(strings.Trim(sb.String(), "\""))
+			if title == shown {
+				continue
+			}
+			shown = title
+			app.QueueUpdateDraw(func() {
+				setPendingTitle(title)
+			})
+		}
+		countRequest(messages, sb.String())
+
+		title := cfg.truncateTitle(strings.Trim(sb.String(), "\""))
+		select {
+		case err := <-errCh:
+			log.Println(err)
+			title = ""
+		default:
+		}
+		if title == "" {
+			// name the chat after the start of the question instead
+			first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+			title = cfg.truncateTitle(first)
+		}
+		titleCh <- title
+	}
+
+	// titleWhenDue suggests the title of the chat named after its first question once it is long enough,
+	// and renames it. It is tried again after the next reply if the chat is still too short, the
+	// suggestion fails or another reply streams meanwhile.
+	titleWhenDue := func(title string, c *Conversation) {
+		tokens, err := NumTokensFromMessages(c.Messages, conversationModel(c))
+		if err != nil || !cfg.titleDue(len(c.Messages), tokens) {
+			return
+		}
+		go func() {
+			suggested, err := askTitle(titleExcerpt(c.Messages))
+			if err != nil {
+				log.Println(err)
+				return
 			}
-			textArea.SetText("", false)
-			textArea.SetDisabled(true)
+			app.QueueUpdateDraw(func() {
+				current, ok := m[title]
+				if !ok || !current.Untitled || streaming {
+					return
+				}
+				for _, taken := m[suggested]; taken; _, taken = m[suggested] {
+					suggested = addSuffixNumber(suggested)
+				}
+				titled := *current
+				titled.Untitled = false
+				if err := saveConversation(title, &titled); err != nil {
+					flash("[red::]%s[-]", err)
+					return
+				}
+				if err := renameConversation(title, suggested); err != nil {
+					flash("[red::]%s[-]", err)
+					return
+				}
+				flash("Named %q after the conversation so far", suggested)
+			})
+		}()
+	}
 
-			titleCh := make(chan string)
-			messages := make([]Message, 0)
-			if textView.GetText(false) == "" {
-				messages = append(messages, Message{
-					Role:    roleSystem,
-					Content: systemMessage,
-				})
+	// submit sends content as the next question of the current conversation and streams the reply.
+	submit := func(content string) {
+		textArea.SetText("", false)
+		textArea.SetDisabled(!cfg.QueueQuestions)
+		streaming = true
+		detached = false
+
+		isScratch := scratch
+		newChat := isNewChat && !isScratch
+		titleCh := make(chan string, 1)
+		titleFromFirstReply := false
+		// knownTitle is the title of a new chat which is not suggested, sent once it is settled.
+		// Every submit has one title producer: knownTitle, suggestTitle or the first reply.
+		knownTitle := ""
+		// untitled is set when the title of a new chat is suggested once it is long enough
+		untitled := false
+		messages := make([]Message, 0)
+		var title string
+		// new chats start with the active model, others keep theirs
+		model := activeModel
+		// and the temperature they were given, new chats follow the config
+		var temperature *float64
+		// and the limit of their context, new chats follow maxTokens
+		var budget int
+		if isScratch {
+			messages = append(messages, Message{
+				Role:    roleSystem,
+				Content: systemMessage,
+			})
+			messages = append(messages, scratchMessages...)
 
-				go func() {
-					resp, err := createChatCompletion([]Message{
-						{
-							Role:    roleUser,
-							Content: prefixSuggestTitle + content,
-						},
-					}, false)
-					if err != nil {
-						log.Panic(err)
-					}
-					defer resp.Body.Close()
+		} else if newChat {
+			messages = append(messages, Message{
+				Role:    roleSystem,
+				Content: systemMessage,
+			})
+			if len(templateSeed) > 0 {
+				// the examples of the template come before the first question
+				messages = append(messages, templateSeed...)
+				textView.SetText(renderConversation(&Conversation{Model: model, Messages: templateSeed}, render))
+				templateSeed = nil
+			} else {
+				textView.Clear()
+			}
 
-					body, err := io.ReadAll(resp.Body)
-					if err != nil {
-						log.Panic(err)
-					}
+			switch {
+			case !cfg.SuggestTitles:
+				// name the chat after the start of the question instead of asking the model
+				first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+				knownTitle = freeTitle(m, cfg.truncateTitle(first))
+			case cfg.deferTitles():
+				// the chat is named after its first question until it is long enough to be titled
+				first, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+				knownTitle = freeTitle(m, cfg.truncateTitle(first))
+				untitled = true
+			case cfg.TitleFrom == titleFromReply:
+				// the title is suggested once the reply arrived
+				titleFromFirstReply = true
+			default:
+				go suggestTitle(content, titleCh)
+			}
+		} else {
+			title, _ = list.GetItemText(list.GetCurrentItem())
+			if c, ok := m[title]; ok {
+				messages = append(messages, c.Messages...)
+				model = conversationModel(c)
+				temperature = c.Temperature
+				budget = c.ContextLimit
+			}
+		}
 
-					var titleResp *Response
-					if err := json.Unmarshal(body, &titleResp); err == nil {
-						titleCh <- titleResp.Choices[0].Message.Content
-					}
-				}()
-			} else {
-				isNewChat = false
+		sentAt := time.Now().Unix()
+		messages = append(messages, Message{
+			Role:    roleUser,
+			Content: content,
+			Time:    sentAt,
+		})
 
-				title, _ := list.GetItemText(list.GetCurrentItem())
-				if c, ok := m[title]; ok {
-					messages = c.Messages
-				}
+		numTokens, err := NumTokensFromMessages(messages, gpt3Dot5Turbo)
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-				textView.ScrollToEnd()
-				fmt.Fprintf(textView, "\n\n")
+		contextReset := false
+		limit := maxTokens
+		if budget > 0 {
+			limit = budget
+		}
+		if numTokens > limit {
+			contextReset = true
+			userContent := content
+			// a new chat already has its title on the way, only a continued one moves to a new chat
+			if !isScratch && !newChat {
+				newChat = true
+				knownTitle = freeTitle(m, title)
+				userContent = fmt.Sprintf("%s: %s", title, content)
 			}
 
+			// the examples of a template and the persona are kept when the context is reset
+			examples := exampleMessages(messages)
+			if i := latestPersona(messages); i >= 0 {
+				examples = append(examples, messages[i])
+			}
+			messages = []Message{
+				{
+					Role:    roleSystem,
+					Content: systemMessage,
+				},
+			}
+			messages = append(messages, examples...)
 			messages = append(messages, Message{
 				Role:    roleUser,
-				Content: content,
+				Content: userContent,
+				Time:    sentAt,
 			})
 
-			numTokens, err := NumTokensFromMessages(messages, gpt3Dot5Turbo)
-			if err != nil {
-				log.Println(err)
-				return nil
+			textView.Clear()
+			fmt.Fprint(textView, contextResetNotice)
+			if render.system {
+				fmt.Fprint(textView, messageSeparator(render.spacing)+systemHeader(systemMessage))
 			}
-
-			if numTokens > maxTokens {
-				isNewChat = true
-				title, _ := list.GetItemText(list.GetCurrentItem())
-				go func() {
-					titleCh <- addSuffixNumber(title)
-				}()
-
-				messages = []Message{
-					{
-						Role:    roleSystem,
-						Content: systemMessage,
-					},
-					{
-						Role:    roleUser,
-						Content: fmt.Sprintf("%s: %s", title, content),
-					},
-				}
-
-				textView.Clear()
+			if len(examples) > 0 {
+				opts := render
+				opts.system = false
+				fmt.Fprint(textView, messageSeparator(render.spacing)+toConversation(examples, opts))
 			}
+		}
+		// a known title is the only one sent, so the send cannot block
+		if knownTitle != "" {
+			titleCh <- knownTitle
+		}
 
-			fmt.Fprintln(textView, "[red::]You:[-]")
-			fmt.Fprintf(textView, "%s\n\n", content)
+		// keep the region IDs in line with toConversation, which never sees the system message
+		userIndex := len(messages) - 1
+		if messages[0].Role == roleSystem {
+			userIndex--
+		}
+		separator := messageSeparator(render.spacing)
+		if textView.GetText(false) != "" {
+			if follow {
+				textView.ScrollToEnd()
+			}
+			fmt.Fprint(textView, separator)
+		} else if render.system {
+			fmt.Fprint(textView, systemHeader(systemMessage)+separator)
+		}
+		fmt.Fprintf(textView, `["%s"]%s`+"\n", messageRegion(userIndex), messageHeader(messages[len(messages)-1], render))
+		fmt.Fprintf(textView, "%s[\"\"]", questionText(content, render))
+		fmt.Fprint(textView, separator)
+		receivedAt := time.Now().Unix()
+		header := render
+		header.model = model
+		fmt.Fprintf(textView, `["%s"]%s`+"\n", messageRegion(userIndex+1), messageHeader(Message{Role: roleAssistant, Time: receivedAt}, header))
+		if newChat {
+			// show the new chat in the history at once, its title follows once it is known
+			pendingTitle = titlePlaceholder
+			if knownTitle != "" {
+				pendingTitle = knownTitle
+			}
+			populating = true
+			list.InsertItem(0, pendingTitle, "", rune(0), nil)
+			populating = false
+		}
+		if tr != nil {
+			name := title
+			switch {
+			case isScratch:
+				name = scratchTitle
+			case newChat:
+				// the title is suggested while the reply streams
+				name = "new chat"
+			}
+			tr.header(name, roleUser, sentAt)
+			fmt.Fprint(tr, content)
+			tr.end(nil)
+			tr.header(name, roleAssistant, receivedAt)
+		}
+		go func() {
+			request := trimContext(messages, cfg.ContextWindow)
+			reply, err := replyWithFallback(model, temperature, request)
+			if tr != nil {
+				tr.end(err)
+			}
+			// a stopped reply is kept as far as it came
+			if errors.Is(err, context.Canceled) && reply.Content != "" {
+				err = nil
+			}
 
-			respCh := make(chan string)
-			errCh := make(chan error, 1)
-			go func() {
-				resp, err := createChatCompletion(messages, true)
-				if err != nil {
-					errCh <- err
+			// the title of a new chat is waited for here, the reply is finished on the event loop
+			var suggested string
+			if newChat && err == nil && reply.Content != "" {
+				if titleFromFirstReply {
+					suggestTitle(reply.Content, titleCh)
 				}
-
-				reader := bufio.NewReader(resp.Body)
-				for {
-					line, err := reader.ReadBytes('\n')
-					if err != nil {
-						if errors.Is(err, io.EOF) {
-							close(respCh)
-							return
-						} else {
-							errCh <- err
-						}
+				suggested = strings.Trim(<-titleCh, "\"")
+			}
+			app.QueueUpdateDraw(func() {
+				if err != nil || reply.Content == "" {
+					switch {
+					case errors.Is(err, context.Canceled):
+						writeReply("[yellow::][stopped[][-]")
+					case err != nil:
+						writeReply(fmt.Sprintf("[red::]%s[-]", tview.Escape(err.Error())))
+					case reply.FinishReason == finishContentFilter:
+						writeReply(contentFilterNotice)
+					default:
+						writeReply("[red::][empty response, try again[][-]")
 					}
-
-					var streamingResp *StreamingResponse
-					if err := json.Unmarshal(bytes.TrimPrefix(line, []byte("data: ")), &streamingResp); err == nil {
-						respCh <- streamingResp.Choices[0].Delta.Content
+					writeReply(`[""]`)
+					if newChat {
+						removePending()
 					}
+					endStream()
+					return
 				}
-			}()
 
-			select {
-			case err := <-errCh:
-				log.Println("received error:", err)
-				return nil
-			default:
-			}
-
-			fmt.Fprintln(textView, "[green::]ChatGPT:[-]")
-			go func() {
-				var fullContent strings.Builder
-				for deltaContent := range respCh {
-					fmt.Fprintf(textView, deltaContent)
-					fullContent.WriteString(deltaContent)
+				if reply.FinishReason == finishContentFilter {
+					writeReply("\n" + contentFilterNotice)
+				}
+				if cfg.JSONMode && !json.Valid([]byte(reply.Content)) {
+					writeReply("\n[yellow::][reply is not valid JSON[][-]")
 				}
 
 				messages = append(messages, Message{
-					Role:    roleAssistant,
-					Content: fullContent.String(),
+					Role:         roleAssistant,
+					Content:      reply.Content,
+					Time:         receivedAt,
+					Reasoning:    reply.Reasoning,
+					Logprobs:     reply.Logprobs,
+					FinishReason: reply.FinishReason,
 				})
+				// note the model which replied if it fell back from the conversation's
+				if reply.Model != model {
+					messages[len(messages)-1].Model = reply.Model
+				}
 
-				if list.GetItemCount() == 0 || isNewChat {
-					list.InsertItem(0, strings.Trim(<-titleCh, "\""), "", rune(0), nil)
-					list.SetCurrentItem(0)
+				if isScratch {
+					scratchMessages = messages[1:]
+					scratchReset = scratchReset || contextReset
+					scratchResponse = reply.ID
+					switch {
+					case !render.markdown && !detached:
+						fmt.Fprint(textView, confidenceNote(reply.Logprobs)+`[""]`)
+					case isShown(scratchTitle, true):
+						setReplyText(renderConversation(&Conversation{Model: activeModel, Messages: scratchMessages, ContextReset: scratchReset}, render))
+					}
+					endStream()
+					return
+				}
 
-					isNewChat = false
+				if newChat {
+					// a suggested title may be taken by another conversation, which it must not overwrite
+					title = freeTitle(m, suggested)
+					removePending()
+					list.InsertItem(0, title, "", rune(0), nil)
+					if !detached {
+						list.SetCurrentItem(0)
+						isNewChat = false
+						recent = touchRecent(recent, title)
+					}
 				}
 
-				title, _ := list.GetItemText(list.GetCurrentItem())
-				c := &Conversation{
-					Time: time.Now().Unix(),
+				// keep what else is stored with the conversation, such as its note
+				c := &Conversation{Model: model, Temperature: temperature, ContextLimit: budget, Untitled: untitled}
+				if prev, ok := m[title]; ok {
+					updated := *prev
+					c = &updated
 				}
+				c.Time = time.Now().Unix()
+				c.ContextReset = c.ContextReset || contextReset
+				c.ResponseID = reply.ID
 				// no need to save the system message into db
 				if messages[0].Role == roleSystem {
 					c.Messages = messages[1:]
@@ -491,52 +2765,445 @@ func main() {
 					c.Messages = messages
 				}
 
-				value, err := json.Marshal(c)
-				if err != nil {
+				finishReply(title, c)
+				if err := saveConversation(title, c); err != nil {
 					log.Panic(err)
 				}
-				db.Update(func(tx *buntdb.Tx) error {
-					_, _, err := tx.Set(title, string(value), nil)
-					return err
-				})
-				m[title] = c
+				refreshItem(title)
+				if c.Untitled {
+					titleWhenDue(title, c)
+				}
+				switch {
+				case !render.markdown && !detached:
+					fmt.Fprint(textView, confidenceNote(reply.Logprobs)+`[""]`)
+				case isShown(title, false):
+					// swap the raw streamed text for the formatted reply,
+					// or for the stored one if the view was switched away meanwhile
+					setReplyText(renderConversation(c, render))
+				}
+				endStream()
+			})
+		}()
+	}
 
-				fmt.Fprintf(textView, "\n\n")
-				textArea.SetDisabled(false)
-			}()
+	confirmModal := tview.NewModal().AddButtons([]string{buttonCancel, buttonSend})
+	// confirmSubmit submits content, asking first if the prompt is large or goes to an expensive model.
+	confirmSubmit := func(content string) {
+		model := activeModel
+		messages := []Message{{Role: roleSystem, Content: systemMessage}}
+		if _, c := currentConversation(); c != nil {
+			model = conversationModel(c)
+			messages = append(messages, c.Messages...)
+		}
+		messages = append(messages, Message{Role: roleUser, Content: content})
+		tokens, err := NumTokensFromMessages(trimContext(messages, cfg.ContextWindow), gpt3Dot5Turbo)
+		if err != nil || !cfg.needsConfirm(model, tokens) {
+			submit(content)
+			return
+		}
+
+		estimate := fmt.Sprintf("%d prompt tokens", tokens)
+		if cost, ok := cfg.promptCost(model, tokens); ok {
+			estimate += fmt.Sprintf(", about $%.4f", cost)
+		}
+		confirmModal.SetText(fmt.Sprintf("Send to %s?\n\n%s", tview.Escape(model), estimate)).
+			SetFocus(1).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				pages.HidePage(pageConfirm)
+				app.SetFocus(textArea)
+				if buttonLabel == buttonSend {
+					submit(content)
+				}
+			})
+		pages.AddPage(pageConfirm, confirmModal, true, true)
+		app.SetFocus(confirmModal)
+	}
+
+	attachInputField := tview.NewInputField().SetLabel("Path: ").SetFieldWidth(60)
+	attachInputField.SetTitle("Attach file").SetBorder(true)
+	attachInputField.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyESC:
+			pages.HidePage(pageAttach)
+			app.SetFocus(textArea)
+		case tcell.KeyEnter:
+			path := attachInputField.GetText()
+			block, err := attachFile(path)
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				return
+			}
+			pages.HidePage(pageAttach)
+			app.SetFocus(textArea)
+
+			// the block goes on lines of its own
+			_, start, end := textArea.GetSelection()
+			if before := textArea.GetText()[:start]; before != "" && !strings.HasSuffix(before, "\n") {
+				block = "\n" + block
+			}
+			textArea.Replace(start, end, block)
+			flash("Attached %s", strings.TrimSpace(path))
+		}
+	})
+
+	// fenceInputField asks for the language of the code block put into the question.
+	fenceInputField := tview.NewInputField().SetLabel("Language: ").SetFieldWidth(20)
+	fenceInputField.SetTitle("Code block (enter without one)").SetBorder(true)
+	fenceInputField.SetDoneFunc(func(key tcell.Key) {
+		pages.HidePage(pageFence)
+		app.SetFocus(textArea)
+		if key != tcell.KeyEnter {
+			return
+		}
+		// a selection goes into the block
+		code, start, end := textArea.GetSelection()
+		block, cursor := fenceScaffold(textArea.GetText()[:start], fenceInputField.GetText(), code)
+		textArea.Replace(start, end, block)
+		textArea.Select(start+cursor, start+cursor)
+	})
+
+	sendQueued = confirmSubmit
+	// unexpanded is the question which was warned about for referencing undefined variables
+	var unexpanded string
+	// lastSent is the question sent last and when, to ignore it being sent again by accident
+	var (
+		lastSent   string
+		lastSentAt time.Time
+	)
+	// reasked is the question which was warned about for being much like the one in similarTitle
+	var reasked, similarTitle string
+	// similarQuestion returns the title of another conversation with a question much like content.
+	similarQuestion := func(content string) (string, bool) {
+		current := ""
+		if !isNewChat && !scratch {
+			current, _ = list.GetItemText(list.GetCurrentItem())
+		}
+		docs := make([]string, 0)
+		titles := make([]string, 0)
+		for title, c := range m {
+			if title == current {
+				continue
+			}
+			for _, msg := range c.Messages {
+				if msg.Role == roleUser && !msg.Example {
+					docs = append(docs, msg.Content)
+					titles = append(titles, title)
+				}
+			}
+		}
+		idx := make(index)
+		idx.add(docs)
+		if i, share := idx.similar(content); i >= 0 && share >= similarThreshold {
+			return titles[i], true
+		}
+		return "", false
+	}
+	textArea.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch boundKey(contextQuestion, event) {
+		case "esc":
+			escape(paneQuestion)
+		case "enter":
+			content := textArea.GetText()
+			if strings.TrimSpace(content) == "" || !checkCap() {
+				return nil
+			}
+			if content == lastSent && time.Since(lastSentAt) < duplicateWindow {
+				flash("[yellow::]Ignored the same question sent again just now[-]")
+				return nil
+			}
+			expanded, undefined := expandVariables(content, cfg.Variables)
+			if len(undefined) > 0 && content != unexpanded {
+				// pressing enter again sends the question as it is
+				unexpanded = content
+				flash("[yellow::]Undefined variables: %s, press enter again to send as is[-]", strings.Join(undefined, ", "))
+				return nil
+			}
+			unexpanded = ""
+			if cfg.SimilarQuestions && content != reasked {
+				if title, ok := similarQuestion(expanded); ok {
+					// pressing enter again sends the question anyway
+					reasked, similarTitle = content, title
+					flash("[yellow::]You asked something similar in %q before, ctrl-n opens it, enter sends anyway[-]", title)
+					return nil
+				}
+			}
+			reasked = ""
+			lastSent, lastSentAt = content, time.Now()
+			if streaming {
+				// the question area only takes questions while a reply streams if they are queued
+				if queued != "" {
+					flash("Replaced the queued question, it is sent once the reply finished")
+				} else {
+					flash("Queued, the question is sent once the reply finished")
+				}
+				queued = expanded
+				textArea.SetText("", false)
+				return nil
+			}
+			confirmSubmit(expanded)
+			return nil
+		case "ctrl-t":
+			// the model does not know what day it is
+			_, start, end := textArea.GetSelection()
+			textArea.Replace(start, end, time.Now().Format(cfg.DateFormat))
+			return nil
+		case "ctrl-n":
+			if _, ok := m[similarTitle]; !ok || reasked == "" {
+				flash("[yellow::]No similar question was found[-]")
+				return nil
+			}
+			// the question is kept, to be sent in that conversation instead
+			if findItem(similarTitle) < 0 {
+				// a search or the archive hides it, but the question is sent to the current item
+				showArchived = showArchived || m[similarTitle].Archived
+				titles := make([]string, 0, len(m))
+				for title := range m {
+					titles = append(titles, title)
+				}
+				setListItems(listed(titles))
+			}
+			list.SetCurrentItem(findItem(similarTitle))
+			showConversation(similarTitle)
+			app.SetFocus(textArea)
+			return nil
+		case "alt-`":
+			fenceInputField.SetText("")
+			pages.ShowPage(pageFence)
+			app.SetFocus(fenceInputField)
+			return nil
+		case "ctrl-o":
+			attachInputField.SetText("")
+			pages.ShowPage(pageAttach)
+			app.SetFocus(attachInputField)
+			return nil
+		case "ctrl-p":
+			text, err := readClipboard()
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				return nil
+			}
+			if text == "" {
+				flash("Clipboard is empty")
+				return nil
+			}
 
+			_, start, end := textArea.GetSelection()
+			textArea.Replace(start, end, text)
+			numTokens, err := NumTokensFromMessages([]Message{
+				{
+					Role:    roleSystem,
+					Content: systemMessage,
+				},
+				{
+					Role:    roleUser,
+					Content: textArea.GetText(),
+				},
+			}, gpt3Dot5Turbo)
+			if err != nil {
+				return nil
+			}
+			if numTokens > maxTokens {
+				flash("[yellow::]The question has %d tokens, more than the limit of %d[-]", numTokens, maxTokens)
+			} else {
+				flash("Pasted the clipboard, the question has %d tokens", numTokens)
+			}
 			return nil
 		}
 		return event
 	})
 
+	chatFlex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(textView, 0, 1, false).
+		AddItem(textArea, clampQuestionHeight(cfg.QuestionHeight), 1, false)
+	// resizeQuestion grows the question by delta rows and remembers its height in the config file.
+	resizeQuestion := func(delta int) {
+		height := clampQuestionHeight(cfg.QuestionHeight + delta)
+		if height == cfg.QuestionHeight {
+			return
+		}
+		cfg.QuestionHeight = height
+		chatFlex.ResizeItem(textArea, height, 1)
+		if err := saveConfigValue(configFile, "question_height", height); err != nil {
+			flash("[red::]Cannot save the question height: %s[-]", err)
+		}
+	}
+
+	// cycleFocus moves the focus step panes along history, conversation and question,
+	// skipping the panes which have nothing to show. It reports false if none of them has the focus.
+	cycleFocus := func(step int) bool {
+		panes := []tview.Primitive{list, textView, textArea}
+		current := -1
+		for i, p := range panes {
+			if app.GetFocus() == p {
+				current = i
+			}
+		}
+		if current < 0 {
+			return false
+		}
+
+		for n := 1; n < len(panes); n++ {
+			next := panes[((current+step*n)%len(panes)+len(panes))%len(panes)]
+			if next == list && list.GetItemCount() == 0 || next == textView && textView.GetText(false) == "" {
+				continue
+			}
+			app.SetFocus(next)
+			break
+		}
+		return true
+	}
+
+	lockView := tview.NewTextView().SetTextAlign(tview.AlignCenter).
+		SetText("\n\n\nLocked after being idle, press any key to unlock")
+	var (
+		// lastInput is when a key was last pressed, to lock the screen once it was idle for long
+		lastInput = time.Now()
+		locked    bool
+		// lockedFocus is focused again once the screen is unlocked
+		lockedFocus tview.Primitive
+	)
+	lock := func() {
+		locked = true
+		lockedFocus = app.GetFocus()
+		// added again to cover the dialogs opened since
+		pages.AddPage(pageLock, lockView, true, true)
+		app.SetFocus(lockView)
+	}
+
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		lastInput = time.Now()
+		if locked {
+			locked = false
+			pages.HidePage(pageLock)
+			app.SetFocus(lockedFocus)
+			return nil
+		}
+
 		if textView.GetText(false) != "" {
 			list.SetSelectedFocusOnly(false)
 		}
 
-		switch event.Key() {
-		case tcell.KeyF1:
-			isNewChat = true
-			list.SetSelectedFocusOnly(true)
-			textView.Clear()
+		key := boundKey(contextGlobal, event)
+		// '?' is typed as usual in the text inputs
+		if key == "?" && app.GetFocus() != list && app.GetFocus() != textView {
+			return event
+		}
+
+		switch key {
+		case "?":
+			helpReturnFocus = app.GetFocus()
+			pages.ShowPage(pageHelp)
+			app.SetFocus(helpView)
+		case "ctrl-up":
+			resizeQuestion(1)
+		case "ctrl-down":
+			resizeQuestion(-1)
+		case "F1":
+			startNewChat()
+		case "F8":
+			pickActiveModel()
+		case "F9":
+			switchRecent()
+		case "F10":
+			if len(cfg.Templates) == 0 {
+				flash("[yellow::]There are no templates, define them in the config[-]")
+				break
+			}
+			pick("New chat from template", templateNames(cfg.Templates), textArea, func(name string) {
+				startNewChat()
+				templateSeed = templateMessages(cfg.Templates[name])
+				textView.SetText(renderConversation(&Conversation{Model: activeModel, Messages: templateSeed}, render))
+				flash("New chat from %q, its examples are sent before the first question", name)
+			})
+		case "F7":
+			if streaming {
+				flash("[yellow::]Wait for the reply to finish[-]")
+				break
+			}
+
+			if scratch {
+				if !isNewChat && list.GetItemCount() > 0 {
+					title, _ := list.GetItemText(list.GetCurrentItem())
+					showConversation(title)
+				} else {
+					startNewChat()
+				}
+				break
+			}
+
+			setScratch(true)
+			textView.SetText(renderConversation(&Conversation{Model: activeModel, Messages: scratchMessages, ContextReset: scratchReset}, render))
+			textView.ScrollToEnd()
 			app.SetFocus(textArea)
-		case tcell.KeyF2:
+		case "F6":
+			cfg.JSONMode = !cfg.JSONMode
+			updateStatus()
+		case "F5":
+			text, err := readClipboard()
+			if err != nil {
+				flash("[red::]%s[-]", err)
+				break
+			}
+			if strings.TrimSpace(text) == "" {
+				flash("Clipboard is empty")
+				break
+			}
+
+			startNewChat()
+			textArea.SetText(text, true)
+			numTokens, err := NumTokensFromMessages([]Message{
+				{
+					Role:    roleSystem,
+					Content: systemMessage,
+				},
+				{
+					Role:    roleUser,
+					Content: text,
+				},
+			}, gpt3Dot5Turbo)
+			if err == nil && numTokens > maxTokens {
+				flash("[yellow::]Clipboard has %d tokens, more than the limit of %d[-]", numTokens, maxTokens)
+			}
+		case "F2":
 			if list.GetItemCount() > 0 {
 				app.SetFocus(list)
 				title, _ := list.GetItemText(list.GetCurrentItem())
-				textView.SetText(toConversation(m[title].Messages))
+				showConversation(title)
 			}
-		case tcell.KeyF3:
+		case "F3":
 			if textView.GetText(false) != "" {
 				app.SetFocus(textView)
 			}
-		case tcell.KeyF4:
+		case "F4":
 			app.SetFocus(textArea)
-		case tcell.KeyCtrlS:
+		case "tab", "shift-tab":
+			step := 1
+			if key == "shift-tab" {
+				step = -1
+			}
+			if !cycleFocus(step) {
+				return event
+			}
+			return nil
+		case "ctrl-s":
 			if list.GetItemCount() > 0 {
 				app.SetFocus(searchInputField)
 			}
+		case "ctrl-g":
+			if lastQuery == "" {
+				flash("Nothing to search again, search with ctrl-s first")
+				break
+			}
+			searchInputField.SetText(lastQuery)
+			search(lastQuery)
+		case "ctrl-r":
+			if streaming {
+				flash("[yellow::]Cannot reload while a reply is streaming[-]")
+				break
+			}
+
+			reloadHistory()
+			flash("Reloaded %d conversations", len(m))
 		default:
 			return event
 		}
@@ -544,24 +3211,105 @@ func main() {
 	})
 
 	help := tview.NewTextView().SetRegions(true).SetDynamicColors(true)
-	help.SetText("F1: new chat, F2: history, F3: conversation, F4: question, enter: submit, ctrl-s: search, j/k: down/up, e: edit, d: delete, ctrl-f/b: page down/up, ctrl-c: quit").SetTextAlign(tview.AlignCenter)
+	help.SetText(footerHelp()).SetTextAlign(tview.AlignCenter)
 
 	mainFlex := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
 			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
 				AddItem(searchInputField, 3, 1, false).
 				AddItem(list, 0, 1, false), 0, 1, false).
-			AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
-				AddItem(textView, 0, 1, false).
-				AddItem(textArea, 5, 1, false), 0, 3, false), 0, 1, false).
-		AddItem(help, 1, 1, false)
+			AddItem(chatFlex, 0, 3, false), 0, 1, false).
+		AddItem(footer.
+			AddItem(help, 0, 1, false).
+			AddItem(stopButton, 0, 0, false).
+			AddItem(statusBar, 60, 1, false), 1, 1, false)
 	pages.
 		AddPage(pageMain, mainFlex, true, true).
-		AddPage(pageEditTitle, modal(editTitleInputField, list.GetCurrentItem()), true, false).
-		AddPage(pageDeleteTitle, deleteTitleModal, true, false)
+		AddPage(pageEditTitle, center(editTitleInputField, titleFieldWidth+4, 3), true, false).
+		AddPage(pageDeleteTitle, deleteTitleModal, true, false).
+		AddPage(pageOverwriteTitle, overwriteTitleModal, true, false).
+		AddPage(pageHelp, helpView, true, false).
+		AddPage(pageDeleteAll, deleteAllModal, true, false).
+		AddPage(pageConfirmAll, center(confirmDeleteAllInputField, 40, 3), true, false).
+		AddPage(pageBackups, center(backupList, 50, 15), true, false).
+		AddPage(pageRestore, restoreModal, true, false).
+		AddPage(pageNote, center(noteTextArea, 60, 10), true, false).
+		AddPage(pageAttach, center(attachInputField, 70, 3), true, false).
+		AddPage(pageFence, center(fenceInputField, 40, 3), true, false).
+		AddPage(pageTemperature, center(temperatureInputField, 52, 3), true, false).
+		AddPage(pageLimit, center(limitInputField, 52, 3), true, false)
+
+	if cfg.IdleLock > 0 {
+		go func() {
+			idle := time.Duration(cfg.IdleLock) * time.Minute
+			ticker := time.NewTicker(idleLockCheck)
+			for range ticker.C {
+				app.QueueUpdateDraw(func() {
+					if !locked && time.Since(lastInput) >= idle {
+						lock()
+					}
+				})
+			}
+		}()
+	}
+
+	if cfg.TrashDays > 0 {
+		retention := time.Duration(cfg.TrashDays) * 24 * time.Hour
+		if _, err := purgeTrash(db, retention, time.Now()); err != nil {
+			log.Println(err)
+		}
+		go func() {
+			ticker := time.NewTicker(trashPurgeInterval)
+			for range ticker.C {
+				app.QueueUpdateDraw(func() {
+					if _, err := purgeTrash(db, retention, time.Now()); err != nil {
+						flash("[red::]Emptying the trash failed: %s[-]", err)
+					}
+				})
+			}
+		}()
+	}
+
+	if cfg.BackupInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(cfg.BackupInterval) * time.Minute)
+			for range ticker.C {
+				// db is only touched from the event loop as it is replaced on reload
+				app.QueueUpdateDraw(func() {
+					if _, err := writeBackup(db, backupDir, cfg.BackupKeep); err != nil {
+						flash("[red::]Backup failed: %s[-]", err)
+					}
+				})
+			}
+		}()
+	}
+
+	if cfg.CompactInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Duration(cfg.CompactInterval) * time.Minute)
+			for range ticker.C {
+				app.QueueUpdateDraw(func() {
+					// it is tried again on the next tick
+					if !streaming {
+						compactHistory()
+					}
+				})
+			}
+		}()
+	}
+
 	if err := app.SetRoot(pages, true).SetFocus(textArea).Run(); err != nil {
 		panic(err)
 	}
+
+	if cfg.CompactOnExit {
+		before, after, err := compactDB(db, dbFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to compact the history:", err)
+			return
+		}
+		fmt.Printf("Compacted the history from %s to %s.\n", formatSize(before), formatSize(after))
+	}
 }
 
 func flock(f *os.File, timeout time.Duration) error {
@@ -609,6 +3357,14 @@ func NumTokensFromMessages(messages []Message, model string) (int, error) {
 	return numTokens, nil
 }
 
+// freeTitle returns title, or title numbered with addSuffixNumber until no conversation in m has it.
+func freeTitle(m map[string]*Conversation, title string) string {
+	for m[title] != nil {
+		title = addSuffixNumber(title)
+	}
+	return title
+}
+
 func addSuffixNumber(title string) string {
 	re := regexp.MustCompile(`(.*)\s-\s(\d+)$`)
 	match := re.FindStringSubmatch(title)
@@ -620,85 +3376,151 @@ func addSuffixNumber(title string) string {
 }
 
 const (
-	completionsURL = "https://api.openai.com/v1/chat/completions"
-	gpt3Dot5Turbo  = "gpt-3.5-turbo"
+	defaultBaseURL         = "https://api.openai.com"
+	defaultCompletionsPath = "/v1/chat/completions"
+	defaultModelsPath      = "/v1/models"
+	gpt3Dot5Turbo          = "gpt-3.5-turbo"
 )
 
-func createChatCompletion(messages []Message, stream bool) (*http.Response, error) {
-	reqBody, err := json.Marshal(&Request{
-		Model:    gpt3Dot5Turbo,
-		Messages: messages,
-		Stream:   stream,
-	})
+// completionsURL is where chat completions are requested. It is set from the config.
+var completionsURL = defaultBaseURL + defaultCompletionsPath
+
+// modelsURL lists the models available to the API key. It is set from the config.
+var modelsURL = defaultBaseURL + defaultModelsPath
+
+// version is stamped at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// userAgent identifies the client in API requests. It is set from the config.
+var userAgent = defaultUserAgent()
+
+func defaultUserAgent() string {
+	return "chatgpt-tui/" + version
+}
+
+// createChatCompletion is replaced by offlineChatCompletion in offline mode.
+// Either way, its requests pass through the request middleware.
+var createChatCompletion = requestChatCompletion
+
+func requestChatCompletion(ctx context.Context, r *Request) (*http.Response, error) {
+	reqBody, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, completionsURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, completionsURL, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
 
 	client := &http.Client{}
 	return client.Do(req)
 }
 
 type Request struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
+	Model            string          `json:"model"`
+	Messages         []Message       `json:"messages"`
+	Stream           bool            `json:"stream"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	Logprobs         bool            `json:"logprobs,omitempty"`
+	TopLogprobs      int             `json:"top_logprobs,omitempty"`
+	N                int             `json:"n,omitempty"`
+	// Stop are sequences the model stops replying at, they are left out of the reply.
+	Stop []string `json:"stop,omitempty"`
+	// PromptCacheKey groups requests which start alike so that their common prefix is served from the cache.
+	PromptCacheKey string `json:"prompt_cache_key,omitempty"`
+	// Header is sent with the request on top of the usual headers, which it can replace.
+	Header http.Header `json:"-"`
 }
 
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+const responseFormatJSON = "json_object"
+
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Time is when the message was sent or received, in Unix seconds. It is never sent to the API.
+	Time int64 `json:"time,omitempty"`
+	// Reasoning is what a reasoning model streamed before its reply. It is never sent to the API.
+	Reasoning string `json:"reasoning,omitempty"`
+	// Model is set on replies from another model than the conversation's,
+	// when they were regenerated with it or came from a fallback model.
+	Model string `json:"model,omitempty"`
+	// Logprobs are the probabilities of the tokens of a reply, if they were asked for. They are never sent to the API.
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+	// Pinned messages are sent with every request even when the context window leaves out older ones.
+	Pinned bool `json:"pinned,omitempty"`
+	// FinishReason is why a reply ended, as the API reported it. It is never sent to the API.
+	FinishReason string `json:"finish_reason,omitempty"`
+	// Persona is the name of the persona a system message in the middle of a conversation switched to.
+	Persona string `json:"persona,omitempty"`
+	// Example messages were seeded from a template to show the model what is expected. Like pinned ones,
+	// they are always sent.
+	Example bool `json:"example,omitempty"`
 }
 
 type Response struct {
-	Id      string `json:"id"`
-	Object  string `json:"object"`
-	Created int    `json:"created"`
-	Choices []struct {
-		Index   int `json:"index"`
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-		FinishReason string `json:"finish_reason"`
-	} `json:"choices"`
-	Usage struct {
+	Id      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int      `json:"created"`
+	Choices []Choice `json:"choices"`
+	Usage   struct {
 		PromptTokens     int `json:"prompt_tokens"`
 		CompletionTokens int `json:"completion_tokens"`
 		TotalTokens      int `json:"total_tokens"`
 	} `json:"usage"`
 }
 
+type Choice struct {
+	Index        int       `json:"index"`
+	Message      Message   `json:"message"`
+	FinishReason string    `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
+}
+
 type StreamingResponse struct {
-	Id      string `json:"id"`
-	Object  string `json:"object"`
-	Created int    `json:"created"`
-	Model   string `json:"model"`
-	Choices []struct {
-		Delta struct {
-			Content string `json:"content"`
-		} `json:"delta"`
-		Index        int         `json:"index"`
-		FinishReason interface{} `json:"finish_reason"`
-	} `json:"choices"`
+	Id                string            `json:"id"`
+	Object            string            `json:"object"`
+	Created           int               `json:"created"`
+	Model             string            `json:"model"`
+	SystemFingerprint string            `json:"system_fingerprint"`
+	Choices           []StreamingChoice `json:"choices"`
 }
 
-func toConversation(messages []Message) string {
-	contents := make([]string, 0)
-	for _, msg := range messages {
-		switch msg.Role {
-		case roleUser:
-			msg.Content = fmt.Sprintf("[red::]You:[-]\n%s", msg.Content)
-		case roleAssistant:
-			msg.Content = fmt.Sprintf("[green::]ChatGPT:[-]\n%s", msg.Content)
-		}
-		contents = append(contents, msg.Content)
-	}
-	return strings.Join(contents, "\n\n")
+type StreamingChoice struct {
+	Delta        Delta       `json:"delta"`
+	Index        int         `json:"index"`
+	FinishReason interface{} `json:"finish_reason"`
+	Logprobs     *Logprobs   `json:"logprobs,omitempty"`
+}
+
+type Delta struct {
+	Content string `json:"content"`
+	// ReasoningContent is streamed by reasoning models before the content.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+// center returns a flex which places p in the middle of the screen with the given size.
+func center(p tview.Primitive, width, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
 }
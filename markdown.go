@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+var (
+	reHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	reListItem   = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	reInlineCode = regexp.MustCompile("`([^`]+)`")
+	reBold       = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	reItalic     = regexp.MustCompile(`(^|[^\w*])[*_]([^*_]+)[*_]([^\w*]|$)`)
+	reTableRow   = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	reTableSep   = regexp.MustCompile(`^\s*\|(\s*:?-+:?\s*\|)+\s*$`)
+)
+
+// formatMarkdown converts markdown into text with tview color tags.
+// With lineNumbers, the lines of code blocks are numbered in a gutter on their left.
+func formatMarkdown(text string, lineNumbers bool) string {
+	lines := strings.Split(text, "\n")
+	var (
+		inCode bool
+		out    = make([]string, 0, len(lines))
+		// codeLine is the number of the current line of a code block, width that of its last line
+		codeLine, width int
+	)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if isFence(line) {
+			inCode = !inCode
+			out = append(out, "[gray::d]"+tview.Escape(line)+"[-::-]")
+			if inCode && lineNumbers {
+				end := i + 1
+				for end < len(lines) && !isFence(lines[end]) {
+					end++
+				}
+				codeLine, width = 0, len(strconv.Itoa(end-i-1))
+			}
+			continue
+		}
+
+		if inCode {
+			gutter := ""
+			if lineNumbers {
+				codeLine++
+				gutter = fmt.Sprintf("[gray::d]%*d │[-::-] ", width, codeLine)
+			}
+			out = append(out, gutter+"[aqua::]"+tview.Escape(line)+"[-]")
+			continue
+		}
+
+		if reTableRow.MatchString(line) && i+1 < len(lines) && reTableSep.MatchString(lines[i+1]) {
+			end := i + 2
+			for end < len(lines) && reTableRow.MatchString(lines[end]) {
+				end++
+			}
+			out = append(out, formatTable(lines[i], lines[i+1], lines[i+2:end])...)
+			i = end - 1
+			continue
+		}
+
+		out = append(out, formatMarkdownLine(line))
+	}
+	return strings.Join(out, "\n")
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// fenceScaffold returns a code block in the given language around code, on lines of its own after before,
+// and where in it the cursor goes: inside an empty block, or after the code.
+func fenceScaffold(before, language, code string) (string, int) {
+	open := "```" + strings.TrimSpace(language) + "\n"
+	if before != "" && !strings.HasSuffix(before, "\n") {
+		open = "\n" + open
+	}
+	if code != "" && !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	if code == "" {
+		return open + "\n```", len(open)
+	}
+	return open + code + "```", len(open) + len(code) - 1
+}
+
+// formatTable draws a markdown table with aligned columns and box drawing borders.
+func formatTable(header, separator string, rows []string) []string {
+	headerCells := tableCells(header)
+	aligns := tableCells(separator)
+	cells := make([][]string, 0, len(rows)+1)
+	cells = append(cells, headerCells)
+	for _, row := range rows {
+		// pad or cut every row to the number of header cells
+		r := append(tableCells(row), make([]string, len(headerCells))...)
+		cells = append(cells, r[:len(headerCells)])
+	}
+
+	widths := make([]int, len(headerCells))
+	for _, row := range cells {
+		for j, cell := range row {
+			cell = formatInline(cell)
+			row[j] = cell
+			if w := tview.TaggedStringWidth(cell); w > widths[j] {
+				widths[j] = w
+			}
+		}
+	}
+
+	border := func(left, middle, right string) string {
+		parts := make([]string, len(widths))
+		for j, w := range widths {
+			parts[j] = strings.Repeat("─", w+2)
+		}
+		return "[gray::d]" + left + strings.Join(parts, middle) + right + "[-::-]"
+	}
+	line := func(row []string, style string) string {
+		parts := make([]string, len(row))
+		for j, cell := range row {
+			align := ""
+			if j < len(aligns) {
+				align = aligns[j]
+			}
+			parts[j] = " " + style + alignCell(cell, widths[j], align) + " "
+			if style != "" {
+				parts[j] += "[::-]"
+			}
+		}
+		sep := "[gray::d]│[-::-]"
+		return sep + strings.Join(parts, sep) + sep
+	}
+
+	out := []string{border("┌", "┬", "┐"), line(cells[0], "[::b]"), border("├", "┼", "┤")}
+	for _, row := range cells[1:] {
+		out = append(out, line(row, ""))
+	}
+	return append(out, border("└", "┴", "┘"))
+}
+
+// tableCells splits a markdown table row into its trimmed cells.
+func tableCells(row string) []string {
+	row = strings.TrimSpace(row)
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	// an escaped pipe belongs to the cell
+	cells := strings.Split(strings.ReplaceAll(row, `\|`, "\x00"), "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(strings.ReplaceAll(cell, "\x00", "|"))
+	}
+	return cells
+}
+
+// alignCell pads cell to width according to the separator cell align, such as ":--" or "--:".
+func alignCell(cell string, width int, align string) string {
+	pad := width - tview.TaggedStringWidth(cell)
+	switch {
+	case strings.HasPrefix(align, ":") && strings.HasSuffix(align, ":"):
+		return strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+	case strings.HasSuffix(align, ":"):
+		return strings.Repeat(" ", pad) + cell
+	default:
+		return cell + strings.Repeat(" ", pad)
+	}
+}
+
+func formatMarkdownLine(line string) string {
+	if match := reHeading.FindStringSubmatch(line); match != nil {
+		return "[yellow::b]" + formatInline(match[2]) + "[-::-]"
+	}
+	if strings.HasPrefix(line, ">") {
+		return "[gray::i]│ " + formatInline(strings.TrimSpace(strings.TrimPrefix(line, ">"))) + "[-::-]"
+	}
+	if match := reListItem.FindStringSubmatch(line); match != nil {
+		return match[1] + "• " + formatInline(match[2])
+	}
+	return formatInline(line)
+}
+
+func formatInline(text string) string {
+	text = tview.Escape(text)
+	text = reInlineCode.ReplaceAllString(text, "[aqua::]$1[-]")
+	text = reBold.ReplaceAllString(text, "[::b]$1[::-]")
+	return reItalic.ReplaceAllString(text, "$1[::i]$2[::-]$3")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// defaultTimeLayout is how times are shown unless the config says otherwise.
+const defaultTimeLayout = "2006-01-02 15:04"
+
+// timeLayout is the Go time layout of the times shown in the history, the trash, the metadata,
+// message headers and exports. It is set from the config.
+var timeLayout = defaultTimeLayout
+
+func formatTime(t time.Time) string {
+	return t.Format(timeLayout)
+}
+
+// conversationMetadata describes a conversation for the metadata panel.
+func conversationMetadata(title string, c *Conversation) string {
+	model := conversationModel(c)
+	tokens := conversationTokens(c)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow::]Title:[-]    %s\n", title)
+	fmt.Fprintf(&b, "[yellow::]Updated:[-]  %s\n", formatTime(time.Unix(c.Time, 0)))
+	fmt.Fprintf(&b, "[yellow::]Messages:[-] %d\n", len(c.Messages))
+	fmt.Fprintf(&b, "[yellow::]Tokens:[-]   %s\n", tokens)
+	fmt.Fprintf(&b, "[yellow::]Model:[-]    %s\n", model)
+	if c.Temperature != nil {
+		fmt.Fprintf(&b, "[yellow::]Temp.:[-]    %g\n", *c.Temperature)
+	} else {
+		b.WriteString("[yellow::]Temp.:[-]    default\n")
+	}
+	if c.ContextLimit > 0 {
+		fmt.Fprintf(&b, "[yellow::]Limit:[-]    %d tokens\n", c.ContextLimit)
+	} else {
+		fmt.Fprintf(&b, "[yellow::]Limit:[-]    default (%d tokens)\n", maxTokens)
+	}
+	if c.ResponseID != "" {
+		fmt.Fprintf(&b, "[yellow::]Response:[-] %s\n", c.ResponseID)
+	}
+	if c.ContextReset {
+		b.WriteString("[yellow::]Context:[-]  reset, earlier messages exceeded the token limit\n")
+	}
+	if c.Note != "" {
+		fmt.Fprintf(&b, "[yellow::]Note:[-]\n%s\n", tview.Escape(c.Note))
+	}
+	return b.String()
+}
+
+// conversationStats breaks down the size of a conversation for the statistics modal.
+func conversationStats(title string, c *Conversation) string {
+	roles := make(map[string]int)
+	words := 0
+	for _, msg := range c.Messages {
+		roles[msg.Role]++
+		words += len(strings.Fields(msg.Content))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", title)
+	fmt.Fprintf(&b, "Messages: %d (%d from you, %d from ChatGPT)\n", len(c.Messages), roles[roleUser], roles[roleAssistant])
+	fmt.Fprintf(&b, "Words: %d\n", words)
+	fmt.Fprintf(&b, "Tokens: %s", conversationTokens(c))
+	return b.String()
+}
+
+// unreadMarker flags conversations in the history which got a reply since they were last shown.
+const unreadMarker = "[aqua::]●[-] "
+
+// isUnread reports whether c got a reply while another conversation was shown.
+func isUnread(c *Conversation) bool {
+	return c.Viewed != 0 && c.Time > c.Viewed
+}
+
+// historyItemText is the secondary text of c in the history list.
+func historyItemText(c *Conversation) string {
+	if c == nil {
+		return ""
+	}
+	text := fmt.Sprintf("%d messages", len(c.Messages))
+	if c.Time != 0 {
+		text += " · " + formatTime(time.Unix(c.Time, 0))
+	}
+	if c.Archived {
+		text = archivedMarker + text
+	}
+	if isUnread(c) {
+		text = unreadMarker + text
+	}
+	return text
+}
+
+func conversationModel(c *Conversation) string {
+	if c.Model == "" {
+		return gpt3Dot5Turbo
+	}
+	return c.Model
+}
+
+// conversationTokens counts the tokens of c, or returns "unknown" if the model has no known encoding.
+func conversationTokens(c *Conversation) string {
+	n, err := NumTokensFromMessages(c.Messages, conversationModel(c))
+	if err != nil {
+		return "unknown"
+	}
+	return fmt.Sprint(n)
+}
+
+const (
+	// titleTurns is how many of the first messages of a conversation a title is suggested from.
+	titleTurns = 6
+	// titleTurnLength is how many characters of each of those messages are quoted.
+	titleTurnLength = 500
+)
+
+// titleExcerpt quotes the start of a conversation for the model to suggest a title from.
+func titleExcerpt(messages []Message) string {
+	var b strings.Builder
+	n := 0
+	for _, msg := range messages {
+		if msg.Role == roleSystem || msg.Example || msg.Content == "" {
+			continue
+		}
+		if n == titleTurns {
+			break
+		}
+		content := []rune(strings.TrimSpace(msg.Content))
+		if len(content) > titleTurnLength {
+			content = append(content[:titleTurnLength], '…')
+		}
+		fmt.Fprintf(&b, "\n%s: %s\n", msg.Role, string(content))
+		n++
+	}
+	return "the conversation below\n" + b.String()
+}
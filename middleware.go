@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// RequestMiddleware inspects or changes a request before it is sent, to log it, filter its content
+// or add headers for example. An error stops the request and is returned in place of its response.
+type RequestMiddleware func(*Request) error
+
+// ResponseObserver is told about the response to a request, or the error it failed with,
+// once the response started. It must not read the body, which is still to be streamed.
+type ResponseObserver func(*Request, *http.Response, error)
+
+var (
+	// requestMiddleware is applied in order to every chat completion request.
+	requestMiddleware []RequestMiddleware
+	// responseObservers are told in order about the response to every chat completion request.
+	responseObservers []ResponseObserver
+)
+
+// useRequestMiddleware adds m to the end of the middleware applied to chat completion requests.
+func useRequestMiddleware(m ...RequestMiddleware) {
+	requestMiddleware = append(requestMiddleware, m...)
+}
+
+// observeResponses adds o to the end of the observers of chat completion responses.
+func observeResponses(o ...ResponseObserver) {
+	responseObservers = append(responseObservers, o...)
+}
+
+// withMiddleware passes the requests made with create through the middleware first
+// and tells the observers about their responses.
+func withMiddleware(create func(context.Context, *Request) (*http.Response, error)) func(context.Context, *Request) (*http.Response, error) {
+	return func(ctx context.Context, r *Request) (*http.Response, error) {
+		for _, m := range requestMiddleware {
+			if err := m(r); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := create(ctx, r)
+		for _, o := range responseObservers {
+			o(r, resp, err)
+		}
+		return resp, err
+	}
+}
+
+// setHeaders is middleware which sends headers with every request, on top of or in place of the usual ones.
+func setHeaders(headers map[string]string) RequestMiddleware {
+	return func(r *Request) error {
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		return nil
+	}
+}
+
+// parseHeader splits the header "Name: value" given on the command line.
+func parseHeader(s string) (name, value string, err error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", errors.New("want Name: value")
+	}
+	return name, strings.TrimSpace(value), nil
+}
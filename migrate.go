@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// metaKeyPrefix marks keys that hold internal data rather than a conversation.
+// The leading NUL byte cannot be typed into a title.
+const metaKeyPrefix = "\x00meta:"
+
+const schemaVersionKey = metaKeyPrefix + "schema_version"
+
+func isMetaKey(key string) bool {
+	return strings.HasPrefix(key, metaKeyPrefix)
+}
+
+// migrations[i] upgrades a conversation from schema version i to i+1.
+// Append new steps to the end, never modify existing ones.
+var migrations = []func(c *Conversation){
+	func(c *Conversation) {
+		if c.Messages == nil {
+			c.Messages = []Message{}
+		}
+		if c.Time == 0 {
+			c.Time = time.Now().Unix()
+		}
+	},
+	func(c *Conversation) {
+		if c.Model == "" {
+			c.Model = gpt3Dot5Turbo
+		}
+	},
+}
+
+// migrate rewrites every conversation stored with an older schema version.
+func migrate(db *buntdb.DB, c *codec) error {
+	return db.Update(func(tx *buntdb.Tx) error {
+		version := 0
+		v, err := tx.Get(schemaVersionKey)
+		if err == nil {
+			version, err = strconv.Atoi(v)
+			if err != nil {
+				return err
+			}
+		} else if !errors.Is(err, buntdb.ErrNotFound) {
+			return err
+		}
+
+		if version >= len(migrations) {
+			return nil
+		}
+
+		updates := make(map[string]string)
+		err = tx.Ascend("", func(key, value string) bool {
+			if isMetaKey(key) {
+				return true
+			}
+
+			conv, err := c.decode(key, value)
+			if err != nil {
+				return true
+			}
+			for _, m := range migrations[version:] {
+				m(conv)
+			}
+			if data, err := c.encode(key, conv); err == nil {
+				updates[key] = data
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, value := range updates {
+			if _, _, err := tx.Set(key, value, nil); err != nil {
+				return err
+			}
+		}
+
+		_, _, err = tx.Set(schemaVersionKey, strconv.Itoa(len(migrations)), nil)
+		return err
+	})
+}
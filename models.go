@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// listModels is replaced by offlineModels in offline mode.
+var listModels = requestModels
+
+var errNoChatModels = errors.New("the API lists no chat models")
+
+// requestModels fetches the chat models available to the API key, sorted by name.
+func requestModels() ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	req.Header.Set("User-Agent", userAgent)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing models: %s", resp.Status)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if isChatModel(m.ID) {
+			models = append(models, m.ID)
+		}
+	}
+	if len(models) == 0 {
+		return nil, errNoChatModels
+	}
+	sort.Strings(models)
+	return models, nil
+}
+
+var (
+	chatModelPrefixes = []string{"gpt-", "chatgpt-", "o1", "o3", "o4"}
+	// nonChatModels are parts of the names of models which do not reply to chat completions,
+	// such as audio and image models of the gpt family.
+	nonChatModels = []string{"instruct", "audio", "realtime", "tts", "transcribe", "image", "search"}
+)
+
+// isChatModel guesses from its name whether the model with the given ID works with the chat completions endpoint.
+func isChatModel(id string) bool {
+	for _, part := range nonChatModels {
+		if strings.Contains(id, part) {
+			return false
+		}
+	}
+	for _, prefix := range chatModelPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
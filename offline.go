@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const offlineDelay = 30 * time.Millisecond
+
+// offlineChatCompletion mimics the completions API by echoing the last message back.
+// Streaming responses are sent word by word in the same format as the real API.
+func offlineChatCompletion(ctx context.Context, r *Request) (*http.Response, error) {
+	var content string
+	if len(r.Messages) > 0 {
+		content = r.Messages[len(r.Messages)-1].Content
+		// title prompts may name a language between the prefix and "for"
+		if rest, ok := strings.CutPrefix(content, strings.TrimSuffix(prefixSuggestTitle, "for ")); ok {
+			_, content, _ = strings.Cut(rest, "for ")
+		}
+	}
+
+	// the reply ends before the first stop sequence, as it would with the API
+	cut := func(reply string) string {
+		for _, stop := range r.Stop {
+			if i := strings.Index(reply, stop); i >= 0 && stop != "" {
+				reply = reply[:i]
+			}
+		}
+		return reply
+	}
+
+	if !r.Stream {
+		var resp Response
+		resp.Id = "offline"
+		resp.Object = "chat.completion"
+		resp.Created = int(time.Now().Unix())
+		resp.Choices = []Choice{
+			{
+				Message: Message{
+					Role:    roleAssistant,
+					Content: cut(content),
+				},
+				FinishReason: "stop",
+			},
+		}
+		if r.N > 1 {
+			resp.Choices = resp.Choices[:0]
+			for i := 0; i < r.N; i++ {
+				resp.Choices = append(resp.Choices, Choice{
+					Index: i,
+					Message: Message{
+						Role:    roleAssistant,
+						Content: cut(fmt.Sprintf("Reply %d of %d, you said: %s", i+1, r.N, content)),
+					},
+					FinishReason: "stop",
+				})
+			}
+		}
+
+		body, err := json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		return offlineResponse(io.NopCloser(bytes.NewReader(body))), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		reply := "You said: " + content
+		if r.ResponseFormat != nil && r.ResponseFormat.Type == responseFormatJSON {
+			data, _ := json.Marshal(map[string]string{"echo": content})
+			reply = string(data)
+		}
+		reply = cut(reply)
+
+		words := strings.SplitAfter(reply, " ")
+		for _, word := range words {
+			var chunk StreamingResponse
+			chunk.Id = "offline"
+			chunk.Object = "chat.completion.chunk"
+			chunk.Created = int(time.Now().Unix())
+			chunk.Model = r.Model
+			chunk.SystemFingerprint = "fp_offline"
+			chunk.Choices = []StreamingChoice{
+				{
+					Delta:    Delta{Content: word},
+					Logprobs: offlineLogprobs(r, word),
+				},
+			}
+
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			fmt.Fprintf(pw, "data: %s\n\n", data)
+			select {
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			case <-time.After(offlineDelay):
+			}
+		}
+		stop, _ := json.Marshal(StreamingResponse{
+			Id:      "offline",
+			Object:  "chat.completion.chunk",
+			Created: int(time.Now().Unix()),
+			Model:   r.Model,
+			Choices: []StreamingChoice{
+				{
+					FinishReason: "stop",
+				},
+			},
+		})
+		fmt.Fprintf(pw, "data: %s\n\n", stop)
+		fmt.Fprint(pw, "data: [DONE]\n\n")
+		pw.Close()
+	}()
+	return offlineResponse(pr), nil
+}
+
+// offlineLogprobs makes up the probability of word if the request asks for it, longer words being less likely.
+func offlineLogprobs(r *Request, word string) *Logprobs {
+	if !r.Logprobs {
+		return nil
+	}
+	lp := TokenLogprob{Token: word, Logprob: -0.1 * float64(len(word))}
+	lp.TopLogprobs = append(lp.TopLogprobs, TopLogprob{Token: word, Logprob: lp.Logprob})
+	for i := 1; i < r.TopLogprobs; i++ {
+		lp.TopLogprobs = append(lp.TopLogprobs, TopLogprob{Token: strings.Repeat("_", i), Logprob: lp.Logprob - float64(i)})
+	}
+	return &Logprobs{Content: []TokenLogprob{lp}}
+}
+
+// offlineModels lists the models offered in offline mode, where any name is accepted.
+func offlineModels() ([]string, error) {
+	return []string{gpt3Dot5Turbo, "gpt-4", "gpt-4o", "gpt-4o-mini"}, nil
+}
+
+func offlineResponse(body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       body,
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// defaultPersona switches a conversation back to the system message new chats start with.
+const defaultPersona = "default"
+
+// personaNames returns the names of the personas in alphabetical order, after the default one
+// unless the config redefines it.
+func personaNames(personas map[string]string) []string {
+	names := make([]string, 0, len(personas)+1)
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if _, ok := personas[defaultPersona]; !ok {
+		names = append([]string{defaultPersona}, names...)
+	}
+	return names
+}
+
+// personaMessage returns the system message which switches a conversation to the persona name.
+func personaMessage(personas map[string]string, name string) Message {
+	prompt, ok := personas[name]
+	if !ok {
+		prompt = systemMessage
+	}
+	return Message{Role: roleSystem, Content: prompt, Time: time.Now().Unix(), Persona: name}
+}
+
+// latestPersona returns the index of the last persona switch in messages, or -1 if there is none.
+func latestPersona(messages []Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Persona != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// personaChanged marks where a conversation switched to the persona name.
+func personaChanged(name string) string {
+	return fmt.Sprintf("[gray::d][persona changed to %s[][-::-]", tview.Escape(name))
+}
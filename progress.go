@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// reProgress matches the progress shown at the end of the header of a streaming reply.
+var reProgress = regexp.MustCompile(` \[gray::d\]\d+%\[-::-\]$`)
+
+// replyProgress shows how much of the max tokens a streaming reply used so far.
+func replyProgress(tokens, max int) string {
+	percent := 100
+	if tokens < max {
+		percent = tokens * 100 / max
+	}
+	return fmt.Sprintf(" [gray::d]%d%%[-::-]", percent)
+}
+
+// withProgress returns text with progress at the end of the header of its last message, in place of
+// the progress shown before.
+func withProgress(text, progress string) string {
+	// the header follows the region tag of the message, see messageRegion
+	start := strings.LastIndex(text, `["msg-`)
+	if start < 0 {
+		return text
+	}
+	end := strings.IndexByte(text[start:], '\n')
+	if end < 0 {
+		return text
+	}
+	end += start
+	return text[:start] + reProgress.ReplaceAllString(text[start:end], "") + progress + text[end:]
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// rateLimits is the remaining quota reported in the headers of a completion response.
+type rateLimits struct {
+	remainingRequests, limitRequests int
+	remainingTokens, limitTokens     int
+}
+
+// parseRateLimits reads the rate limit headers of h. It reports false if they are missing,
+// as they are for servers other than OpenAI.
+func parseRateLimits(h http.Header) (rateLimits, bool) {
+	var (
+		l   rateLimits
+		err error
+	)
+	for header, v := range map[string]*int{
+		"x-ratelimit-remaining-requests": &l.remainingRequests,
+		"x-ratelimit-limit-requests":     &l.limitRequests,
+		"x-ratelimit-remaining-tokens":   &l.remainingTokens,
+		"x-ratelimit-limit-tokens":       &l.limitTokens,
+	} {
+		if *v, err = strconv.Atoi(h.Get(header)); err != nil {
+			return rateLimits{}, false
+		}
+	}
+	return l, true
+}
+
+// rateLimitWarning is the share of a quota below which it is shown as running low.
+const rateLimitWarning = 0.1
+
+func (l rateLimits) String() string {
+	return fmt.Sprintf("req: %s, tok: %s",
+		quota(l.remainingRequests, l.limitRequests, strconv.Itoa),
+		quota(l.remainingTokens, l.limitTokens, abbreviate))
+}
+
+func quota(remaining, limit int, format func(int) string) string {
+	s := format(remaining) + "/" + format(limit)
+	if float64(remaining) < rateLimitWarning*float64(limit) {
+		return "[yellow::]" + s + "[-]"
+	}
+	return s
+}
+
+// abbreviate formats n in thousands or millions, such as 89k.
+func abbreviate(n int) string {
+	switch {
+	case n >= 1000000:
+		return strconv.Itoa(n/1000000) + "M"
+	case n >= 1000:
+		return strconv.Itoa(n/1000) + "k"
+	default:
+		return strconv.Itoa(n)
+	}
+}
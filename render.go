@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// roleLabel returns the colored header printed above a message of the given role.
+func roleLabel(role string) string {
+	switch role {
+	case roleUser:
+		return "[red::]You:[-]"
+	case roleAssistant:
+		return "[green::]ChatGPT:[-]"
+	case roleSystem:
+		return "[gray::d]System:[-::-]"
+	case roleTool:
+		return "[yellow::]Tool:[-]"
+	default:
+		if role == "" {
+			role = "unknown"
+		}
+		return fmt.Sprintf("[blue::]%s:[-]", strings.ToUpper(role[:1])+role[1:])
+	}
+}
+
+// modelColor returns the color of the label of replies by model in colors. A color given for a model
+// also applies to its versions, "gpt-4" to "gpt-4-0613" for example, unless they have their own.
+// It returns "" if no color is given for model or the color is not known.
+func modelColor(colors map[string]string, model string) string {
+	var match, color string
+	for name, c := range colors {
+		if strings.HasPrefix(model, name) && len(name) > len(match) {
+			match, color = name, c
+		}
+	}
+	if color == "" || tcell.GetColor(color) == tcell.ColorDefault {
+		return ""
+	}
+	return color
+}
+
+// pinnedMarker is added to the header of a pinned message.
+const pinnedMarker = "[yellow::]pinned[-]"
+
+// messageHeader returns the role label of msg, followed by the model which produced it if that
+// is not the conversation's, by its time when opts.timestamps is set and by markers if it is a reply
+// written from right to left, if it is pinned or if it is an example of a template.
+// Replies are labeled in the color of their model.
+func messageHeader(msg Message, opts renderOptions) string {
+	label := roleLabel(msg.Role)
+	if msg.Role == roleAssistant {
+		model := msg.Model
+		if model == "" {
+			model = opts.model
+		}
+		if color := modelColor(opts.modelColors, model); color != "" {
+			label = fmt.Sprintf("[%s::]ChatGPT:[-]", color)
+		}
+	}
+	if msg.Model != "" {
+		label += " [gray::d](" + tview.Escape(msg.Model) + ")[-::-]"
+	}
+	if opts.timestamps && msg.Time != 0 {
+		label += " " + dimmed(tview.Escape(formatTime(time.Unix(msg.Time, 0))))
+	}
+	if msg.Role == roleAssistant && isRTL(msg.Content) {
+		label += " " + rtlMarker
+	}
+	if msg.Pinned {
+		label += " " + pinnedMarker
+	}
+	if msg.Example {
+		label += " " + exampleMarker
+	}
+	return label
+}
+
+// messageRegion returns the region ID which wraps the i-th rendered message.
+func messageRegion(i int) string {
+	return fmt.Sprintf("msg-%d", i)
+}
+
+// renderOptions controls how toConversation formats messages.
+type renderOptions struct {
+	// markdown formats assistant replies instead of showing them as raw text.
+	markdown bool
+	// spacing is the number of blank lines between messages.
+	spacing int
+	// timestamps shows the time of each message next to its header.
+	timestamps bool
+	// system shows the system message at the top of the conversation.
+	system bool
+	// reasoning shows the reasoning of a reply above its content instead of a collapsed line.
+	reasoning bool
+	// compact shows only the latest exchange, from the last question on.
+	compact bool
+	// lineNumbers numbers the lines of code blocks in formatted replies.
+	lineNumbers bool
+	// rtl reverses replies written from right to left so that they read correctly in the terminal.
+	rtl bool
+	// modelColors are the colors of the label of replies by each model.
+	modelColors map[string]string
+	// model is the model of the conversation, which replies that do not note their own come from.
+	model string
+	// folded are the indices of the replies which only show their first line.
+	folded map[int]bool
+	// literal shows questions as they were typed, escaping what would be taken for color tags.
+	literal bool
+	// newestFirst shows the latest exchange at the top and the first one at the bottom.
+	newestFirst bool
+}
+
+// latestExchange returns the index of the last question in messages, where the compact view starts.
+func latestExchange(messages []Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == roleUser {
+			return i
+		}
+	}
+	return 0
+}
+
+// firstUnanswered returns the index of the first question in messages which got no reply, because
+// the request failed or the reply was empty, or -1 if every question was answered.
+func firstUnanswered(messages []Message) int {
+	for i, msg := range messages {
+		if msg.Role != roleUser {
+			continue
+		}
+		if i+1 == len(messages) || messages[i+1].Role != roleAssistant || strings.TrimSpace(messages[i+1].Content) == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// earlierHidden stands in for the messages the compact view leaves out.
+func earlierHidden(n int) string {
+	if n == 1 {
+		return dimmed("▸ 1 earlier message hidden, press c to show")
+	}
+	return dimmed(fmt.Sprintf("▸ %d earlier messages hidden, press c to show", n))
+}
+
+// foldedNote stands in for the n lines of a folded reply after its first one.
+func foldedNote(n int) string {
+	if n == 1 {
+		return dimmed("▸ 1 more line, press z to expand")
+	}
+	return dimmed(fmt.Sprintf("▸ %d more lines, press z to expand", n))
+}
+
+// reasoningCollapsed stands in for the reasoning of a reply while it is hidden.
+const reasoningCollapsed = "[gray::d]▸ reasoning hidden, press r to show[-::-]"
+
+func dimmed(text string) string {
+	return "[gray::d]" + text + "[-::-]"
+}
+
+const maxMessageSpacing = 3
+
+// messageSeparator returns the text written between two messages.
+func messageSeparator(spacing int) string {
+	if spacing < 0 {
+		spacing = 0
+	} else if spacing > maxMessageSpacing {
+		spacing = maxMessageSpacing
+	}
+	return "\n" + strings.Repeat("\n", spacing)
+}
+
+// systemHeader returns the dimmed system message printed above a conversation.
+// It is not wrapped in a region so the region IDs keep matching the stored messages.
+func systemHeader(content string) string {
+	return fmt.Sprintf("%s\n[gray::d]%s[-::-]", roleLabel(roleSystem), content)
+}
+
+// contextResetNotice is shown above a conversation which was started over
+// because the previous messages exceeded the token limit.
+const contextResetNotice = "[yellow::][context reset: previous messages exceeded token limit[][-]"
+
+// renderConversation renders the messages of c, noting whether its context was reset.
+func renderConversation(c *Conversation, opts renderOptions) string {
+	opts.model = conversationModel(c)
+	opts.folded = c.Folded
+	text := toConversation(c.Messages, opts)
+	if !c.ContextReset {
+		return text
+	}
+	if text == "" {
+		return contextResetNotice
+	}
+	return contextResetNotice + messageSeparator(opts.spacing) + text
+}
+
+// tabWidth is how many columns a tab of a literal question moves to the next multiple of.
+const tabWidth = 4
+
+// questionText returns content of a question as it is shown with opts. Literal questions get their tabs
+// expanded, which the terminal would show as a single column, and their brackets escaped.
+func questionText(content string, opts renderOptions) string {
+	if !opts.literal {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return tview.Escape(strings.Join(lines, "\n"))
+}
+
+// expandTabs replaces the tabs of line with the spaces up to the next tab stop.
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := tabWidth - column%tabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			column += n
+			continue
+		}
+		b.WriteRune(r)
+		column++
+	}
+	return b.String()
+}
+
+// countShown returns the number of messages which are shown with opts.
+func countShown(messages []Message, opts renderOptions) int {
+	n := 0
+	for _, msg := range messages {
+		if msg.Role != roleSystem || opts.system || msg.Persona != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func toConversation(messages []Message, opts renderOptions) string {
+	contents := make([]string, 0)
+	start := 0
+	if opts.compact {
+		start = latestExchange(messages)
+		if hidden := countShown(messages[:start], opts); hidden > 0 {
+			contents = append(contents, earlierHidden(hidden))
+		}
+	}
+	if opts.system && start == 0 && (len(messages) == 0 || messages[0].Role != roleSystem) {
+		// the system message is not saved with the conversation, show the one sent with new chats
+		contents = append(contents, systemHeader(systemMessage))
+	}
+	// the blocks of the messages shown, and where each exchange starts among them
+	blocks := make([]string, 0, len(messages))
+	exchanges := make([]int, 0)
+	for i, msg := range messages {
+		if i < start || msg.Role == roleSystem && !opts.system && msg.Persona == "" {
+			continue
+		}
+		if msg.Persona != "" && !opts.system {
+			// the prompt of a persona only shows along with the system message
+			blocks = append(blocks, fmt.Sprintf(`["%s"]%s[""]`, messageRegion(i), personaChanged(msg.Persona)))
+			continue
+		}
+		content := msg.Content
+		if msg.Role == roleUser {
+			content = questionText(content, opts)
+		}
+		if msg.Role == roleAssistant && opts.rtl && isRTL(content) {
+			content = reverseRTL(content)
+		}
+		more := strings.Count(strings.TrimRight(content, "\n"), "\n")
+		folded := msg.Role == roleAssistant && opts.folded[i] && more > 0
+		if folded {
+			content, _, _ = strings.Cut(content, "\n")
+		}
+		switch {
+		case msg.Role == roleSystem || msg.Example:
+			content = fmt.Sprintf("[gray::d]%s[-::-]", content)
+		case msg.Role == roleAssistant && opts.markdown:
+			content = formatMarkdown(content, opts.lineNumbers)
+		}
+		if msg.Role != roleSystem {
+			content = underlineLinks(content)
+		}
+		if msg.Persona != "" {
+			content = personaChanged(msg.Persona) + "\n" + content
+		}
+		if msg.FinishReason == finishContentFilter {
+			content += "\n" + contentFilterNotice
+		}
+		content += confidenceNote(msg.Logprobs)
+		if folded {
+			content += "\n" + foldedNote(more)
+		}
+		if msg.Reasoning != "" {
+			if opts.reasoning {
+				content = dimmed(msg.Reasoning) + "\n\n" + content
+			} else {
+				content = reasoningCollapsed + "\n" + content
+			}
+		}
+		block := fmt.Sprintf(`["%s"]%s`+"\n"+`%s[""]`, messageRegion(i), messageHeader(msg, opts), content)
+		if msg.Role == roleSystem && len(blocks) == 0 {
+			// the system message stays at the top in either order
+			contents = append(contents, block)
+			continue
+		}
+		if msg.Role == roleUser || len(exchanges) == 0 {
+			exchanges = append(exchanges, len(blocks))
+		}
+		blocks = append(blocks, block)
+	}
+	if opts.newestFirst {
+		blocks = reverseExchanges(blocks, exchanges)
+	}
+	contents = append(contents, blocks...)
+	return strings.Join(contents, messageSeparator(opts.spacing))
+}
+
+// reverseExchanges returns blocks with the exchanges starting at the given indices in reverse order.
+// The messages within an exchange keep theirs, so that a question still comes before its reply.
+func reverseExchanges(blocks []string, exchanges []int) []string {
+	reversed := make([]string, 0, len(blocks))
+	end := len(blocks)
+	for i := len(exchanges) - 1; i >= 0; i-- {
+		reversed = append(reversed, blocks[exchanges[i]:end]...)
+		end = exchanges[i]
+	}
+	return reversed
+}
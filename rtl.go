@@ -0,0 +1,74 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// rtlScripts are written from right to left.
+var rtlScripts = []*unicode.RangeTable{unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko}
+
+// rtlMarker is added to the header of a reply which is mostly written from right to left.
+const rtlMarker = "[gray::d]RTL[-::-]"
+
+func isRTLRune(r rune) bool {
+	return unicode.IsOneOf(rtlScripts, r)
+}
+
+// isRTL reports whether most letters of text belong to a right-to-left script.
+func isRTL(text string) bool {
+	var rtl, letters int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if isRTLRune(r) {
+			rtl++
+		}
+	}
+	return letters > 0 && 2*rtl > letters
+}
+
+// reLinePrefix matches the markdown which starts a line and stays in front of it when it is reversed.
+var reLinePrefix = regexp.MustCompile(`^\s*(?:#{1,6}|[-*+>]|\d+[.)])\s+`)
+
+// reverseRTL lays out text in visual order for terminals which only write from left to right.
+// The words of every line are reversed and so are the letters of right-to-left words,
+// while other words, such as numbers and links, and code blocks keep their order.
+// This is a rough approximation of the bidirectional algorithm.
+func reverseRTL(text string) string {
+	lines := strings.Split(text, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode || strings.IndexFunc(line, isRTLRune) < 0 {
+			continue
+		}
+
+		prefix := reLinePrefix.FindString(line)
+		words := strings.Fields(line[len(prefix):])
+		for j, k := 0, len(words)-1; j < k; j, k = j+1, k-1 {
+			words[j], words[k] = words[k], words[j]
+		}
+		for j, word := range words {
+			if strings.IndexFunc(word, isRTLRune) >= 0 {
+				words[j] = reverseRunes(word)
+			}
+		}
+		lines[i] = prefix + strings.Join(words, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func reverseRunes(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
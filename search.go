@@ -105,6 +105,40 @@ func intersection(a, b []int) []int {
 	return r
 }
 
+// similarThreshold is the share of their terms two questions have in common from which they count as similar.
+const similarThreshold = 0.6
+
+// similar returns the document sharing the largest share of its terms with text, and that share,
+// which is the number of terms in common over the number of terms in either. It returns -1 if no document
+// has a term of text.
+func (idx index) similar(text string) (int, float64) {
+	terms := make(map[string]bool)
+	for _, token := range analyze(text) {
+		terms[token] = true
+	}
+	sizes := make(map[int]int)
+	for _, ids := range idx {
+		for _, id := range ids {
+			sizes[id]++
+		}
+	}
+	shared := make(map[int]int)
+	for term := range terms {
+		for _, id := range idx[term] {
+			shared[id]++
+		}
+	}
+
+	best, bestShare := -1, 0.0
+	for id, n := range shared {
+		share := float64(n) / float64(len(terms)+sizes[id]-n)
+		if share > bestShare || share == bestShare && id < best {
+			best, bestShare = id, share
+		}
+	}
+	return best, bestShare
+}
+
 func (idx index) search(text string) []int {
 	var r []int
 	for _, token := range analyze(text) {
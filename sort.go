@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type sortMode int
+
+const (
+	sortByTime sortMode = iota
+	sortByTitle
+	sortBySize
+)
+
+func (s sortMode) String() string {
+	switch s {
+	case sortByTitle:
+		return "title"
+	case sortBySize:
+		return "size"
+	default:
+		return "recent"
+	}
+}
+
+func (s sortMode) next() sortMode {
+	return (s + 1) % 3
+}
+
+// sortTitles orders titles in place: newest first, alphabetically or by the number of messages.
+func sortTitles(titles []string, m map[string]*Conversation, mode sortMode) {
+	sort.SliceStable(titles, func(i, j int) bool {
+		a, b := m[titles[i]], m[titles[j]]
+		if a == nil || b == nil {
+			return a != nil
+		}
+
+		switch mode {
+		case sortByTitle:
+			return strings.ToLower(titles[i]) < strings.ToLower(titles[j])
+		case sortBySize:
+			if len(a.Messages) != len(b.Messages) {
+				return len(a.Messages) > len(b.Messages)
+			}
+		}
+		return a.Time > b.Time
+	})
+}
+
+// maxRecent is the number of conversations offered by the switcher, one for each digit key.
+const maxRecent = 9
+
+// touchRecent moves title to the front of recent, which lists the conversations used in the session
+// from the most recent one on.
+func touchRecent(recent []string, title string) []string {
+	for i, t := range recent {
+		if t == title {
+			copy(recent[1:i+1], recent[:i])
+			recent[0] = title
+			return recent
+		}
+	}
+	return append([]string{title}, recent...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// streamedReply collects the chunks of a streamed reply.
+type streamedReply struct {
+	ID                string
+	Content           string
+	Reasoning         string
+	SystemFingerprint string
+	Logprobs          []TokenLogprob
+	// Model is the model which replied, which differs from the one asked after falling back.
+	Model string
+	// FinishReason is why the reply ended, such as "stop" or "content_filter".
+	FinishReason string
+
+	// thinking is set once reasoning was displayed and until the content starts.
+	thinking bool
+}
+
+// add merges chunk into the reply and returns the reasoning and content it carried.
+func (r *streamedReply) add(chunk *StreamingResponse) (reasoning, content string) {
+	if chunk.Id != "" {
+		r.ID = chunk.Id
+	}
+	if chunk.SystemFingerprint != "" {
+		r.SystemFingerprint = chunk.SystemFingerprint
+	}
+	if len(chunk.Choices) == 0 {
+		return "", ""
+	}
+
+	if reason, ok := chunk.Choices[0].FinishReason.(string); ok && reason != "" {
+		r.FinishReason = reason
+	}
+	if lp := chunk.Choices[0].Logprobs; lp != nil {
+		r.Logprobs = append(r.Logprobs, lp.Content...)
+	}
+	delta := chunk.Choices[0].Delta
+	r.Reasoning += delta.ReasoningContent
+	r.Content += delta.Content
+	return delta.ReasoningContent, delta.Content
+}
+
+// display returns the text to write for the next deltas of the reply,
+// laid out the same way as toConversation lays out a received reply.
+func (r *streamedReply) display(reasoning, content string, showReasoning bool) string {
+	var sb strings.Builder
+	if reasoning != "" {
+		if !r.thinking && !showReasoning {
+			sb.WriteString(reasoningCollapsed)
+		}
+		if showReasoning {
+			sb.WriteString(dimmed(reasoning))
+		}
+		r.thinking = true
+	}
+	if content != "" && r.thinking {
+		r.thinking = false
+		if showReasoning {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(content)
+	return sb.String()
+}
+
+// finishContentFilter is the finish reason of a reply which was blocked by the content filter of the API.
+const finishContentFilter = "content_filter"
+
+// contentFilterNotice follows the part of a reply which came before the content filter blocked it.
+const contentFilterNotice = "[red::][response blocked by content filter[][-]"
+
+var errStreamInterrupted = errors.New("stream ended before the reply was complete")
+
+// streamChatCompletion sends a streaming request and sends every chunk to respCh.
+// respCh is always closed when the stream ends; a failure is sent to errCh beforehand.
+// Cancelling ctx stops the stream with ctx.Err().
+func streamChatCompletion(ctx context.Context, r *Request, respCh chan<- *StreamingResponse, errCh chan<- error) {
+	defer close(respCh)
+
+	resp, err := createChatCompletion(ctx, r)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		errCh <- err
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		errCh <- fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+		return
+	}
+
+	var finished bool
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				errCh <- ctx.Err()
+			} else if !errors.Is(err, io.EOF) {
+				errCh <- err
+			} else if !finished {
+				errCh <- errStreamInterrupted
+			}
+			return
+		}
+
+		data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data: ")))
+		if bytes.Equal(data, []byte("[DONE]")) {
+			return
+		}
+
+		var streamingResp *StreamingResponse
+		if err := json.Unmarshal(data, &streamingResp); err == nil {
+			for _, choice := range streamingResp.Choices {
+				if choice.FinishReason != nil {
+					finished = true
+				}
+			}
+			respCh <- streamingResp
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sort"
+
+// exampleMarker is added to the header of a message seeded from a template.
+const exampleMarker = "[gray::d]example[-::-]"
+
+// templateNames returns the names of templates in alphabetical order.
+func templateNames(templates map[string][]Message) []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateMessages returns the example turns of a template to seed a new chat with.
+// Messages without content are left out.
+func templateMessages(template []Message) []Message {
+	messages := make([]Message, 0, len(template))
+	for _, msg := range template {
+		if msg.Content == "" {
+			continue
+		}
+		if msg.Role == "" {
+			msg.Role = roleUser
+		}
+		messages = append(messages, Message{Role: msg.Role, Content: msg.Content, Example: true})
+	}
+	return messages
+}
+
+// exampleMessages returns the messages of a conversation which were seeded from a template.
+func exampleMessages(messages []Message) []Message {
+	examples := make([]Message, 0)
+	for _, msg := range messages {
+		if msg.Example {
+			examples = append(examples, msg)
+		}
+	}
+	return examples
+}
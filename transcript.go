@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// transcript appends every message to a plain text file while it is streamed,
+// independently of the history database.
+type transcript struct {
+	f *os.File
+}
+
+func openTranscript(path string) (*transcript, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &transcript{f: f}, nil
+}
+
+// Write appends a streamed delta to the current message.
+func (t *transcript) Write(p []byte) (int, error) {
+	return t.f.Write(p)
+}
+
+// header starts a message of role in the conversation title.
+func (t *transcript) header(title, role string, at int64) {
+	fmt.Fprintf(t.f, "[%s] %s (%s):\n", time.Unix(at, 0).Format(time.DateTime), roleName(role), title)
+}
+
+// end finishes the current message, noting err if it was cut short.
+func (t *transcript) end(err error) {
+	if err != nil {
+		fmt.Fprintf(t.f, "\n[error: %v]", err)
+	}
+	fmt.Fprint(t.f, "\n\n")
+}
+
+func (t *transcript) Close() error {
+	return t.f.Close()
+}
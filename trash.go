@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// trashKeyPrefix marks deleted conversations which can still be restored.
+// It is a meta key so that the trash never shows up among the conversations.
+const trashKeyPrefix = metaKeyPrefix + "trash:"
+
+// trashPurgeInterval is how often the conversations deleted before the retention period are purged.
+const trashPurgeInterval = time.Hour
+
+// trashedConversation is stored under the trash key of a deleted conversation.
+type trashedConversation struct {
+	// Deleted is when the conversation was moved to the trash, in Unix seconds.
+	Deleted int64 `json:"deleted"`
+	// Value is the conversation as it was stored, encrypted if the history is.
+	Value string `json:"value"`
+}
+
+// trashItem is a conversation in the trash.
+type trashItem struct {
+	Title   string
+	Deleted time.Time
+}
+
+func trashKey(title string) string {
+	return trashKeyPrefix + title
+}
+
+func isTrashKey(key string) bool {
+	return strings.HasPrefix(key, trashKeyPrefix)
+}
+
+// moveToTrash deletes the conversation with the given title and keeps it in the trash.
+// A conversation deleted earlier under the same title is replaced.
+func moveToTrash(tx *buntdb.Tx, title string, now time.Time) error {
+	value, err := tx.Delete(title)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(trashedConversation{Deleted: now.Unix(), Value: value})
+	if err != nil {
+		return err
+	}
+	_, _, err = tx.Set(trashKey(title), string(data), nil)
+	return err
+}
+
+// listTrash returns the conversations in the trash, the most recently deleted first.
+func listTrash(db *buntdb.DB) ([]trashItem, error) {
+	items := make([]trashItem, 0)
+	err := db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(trashKeyPrefix+"*", func(key, value string) bool {
+			var trashed trashedConversation
+			if json.Unmarshal([]byte(value), &trashed) == nil {
+				items = append(items, trashItem{Title: strings.TrimPrefix(key, trashKeyPrefix), Deleted: time.Unix(trashed.Deleted, 0)})
+			}
+			return true
+		})
+	})
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return items, err
+}
+
+// restoreFromTrash takes the conversation deleted under trashed out of the trash, stores it as title
+// and returns it. It is stored again with c, as an encrypted value is sealed with its title.
+func restoreFromTrash(tx *buntdb.Tx, c *codec, trashed, title string) (*Conversation, error) {
+	value, err := tx.Delete(trashKey(trashed))
+	if err != nil {
+		return nil, err
+	}
+	var t trashedConversation
+	if err := json.Unmarshal([]byte(value), &t); err != nil {
+		return nil, err
+	}
+	conv, err := c.decode(trashed, t.Value)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = c.encode(title, conv); err != nil {
+		return nil, err
+	}
+	if _, _, err := tx.Set(title, value, nil); err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// purgeTrash removes the conversations which were deleted longer than retention ago
+// and returns how many there were.
+func purgeTrash(db *buntdb.DB, retention time.Duration, now time.Time) (int, error) {
+	expired := make([]string, 0)
+	err := db.Update(func(tx *buntdb.Tx) error {
+		err := tx.AscendKeys(trashKeyPrefix+"*", func(key, value string) bool {
+			var trashed trashedConversation
+			if json.Unmarshal([]byte(value), &trashed) != nil || now.Sub(time.Unix(trashed.Deleted, 0)) > retention {
+				expired = append(expired, key)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if _, err := tx.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// emptyTrash removes every conversation in the trash.
+func emptyTrash(tx *buntdb.Tx) error {
+	keys := make([]string, 0)
+	err := tx.AscendKeys(trashKeyPrefix+"*", func(key, value string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recodeTrashed re-encodes the conversation deleted under title and kept in the trash value
+// with next after decoding it with current.
+func recodeTrashed(title, value string, current, next *codec) (string, error) {
+	var trashed trashedConversation
+	if err := json.Unmarshal([]byte(value), &trashed); err != nil {
+		return "", err
+	}
+	c, err := current.decode(title, trashed.Value)
+	if err != nil {
+		return "", err
+	}
+	if trashed.Value, err = next.encode(title, c); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(trashed)
+	return string(data), err
+}
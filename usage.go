@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errSessionCapped = errors.New("the session reached its cap")
+
+// sessionUsage counts the requests and tokens sent to the API since the start,
+// so that the user can cap what a session spends.
+type sessionUsage struct {
+	tokens, requests int
+	// tokenCap and requestCap block further requests once reached. Zero sets no cap.
+	tokenCap, requestCap int
+	// override lets requests through after the user confirmed going over a cap.
+	override bool
+}
+
+// add records a request which used the given number of tokens.
+func (u *sessionUsage) add(tokens int) {
+	u.tokens += tokens
+	u.requests++
+}
+
+func (u *sessionUsage) reached() bool {
+	return u.tokenCap > 0 && u.tokens >= u.tokenCap || u.requestCap > 0 && u.requests >= u.requestCap
+}
+
+// capped reports whether a cap was reached and not overridden.
+func (u *sessionUsage) capped() bool {
+	return u.reached() && !u.override
+}
+
+// String shows the usage against the caps, or nothing if no cap is set.
+func (u *sessionUsage) String() string {
+	items := make([]string, 0, 2)
+	if u.tokenCap > 0 {
+		items = append(items, fmt.Sprintf("%s/%s tok", abbreviate(u.tokens), abbreviate(u.tokenCap)))
+	}
+	if u.requestCap > 0 {
+		items = append(items, fmt.Sprintf("%d/%d req", u.requests, u.requestCap))
+	}
+	if len(items) == 0 {
+		return ""
+	}
+
+	s := "usage: " + strings.Join(items, ", ")
+	if u.reached() {
+		return "[yellow::]" + s + "[-]"
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// reVariable matches a reference to a prompt variable, such as ${LANG}.
+	reVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// reVariableName matches the names variables can be defined with.
+	reVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// expandVariables replaces the references to variables in content with their values.
+// Variables may be defined with or without a leading $. References to variables which are not defined
+// are left as they are and returned once each, so that they can be warned about.
+func expandVariables(content string, vars map[string]string) (string, []string) {
+	var undefined []string
+	seen := make(map[string]bool)
+	expanded := reVariable.ReplaceAllStringFunc(content, func(ref string) string {
+		name := reVariable.FindStringSubmatch(ref)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		if value, ok := vars["$"+name]; ok {
+			return value
+		}
+		if !seen[name] {
+			seen[name] = true
+			undefined = append(undefined, name)
+		}
+		return ref
+	})
+	return expanded, undefined
+}
+
+// parseVariable splits the definition NAME=value given on the command line.
+func parseVariable(s string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(s, "=")
+	name = strings.TrimPrefix(strings.TrimSpace(name), "$")
+	return name, value, ok && reVariableName.MatchString(name)
+}